@@ -3,14 +3,22 @@ package main
 import (
 	"github.com/bogdancanciu/frekathon-backend/handlers"
 	"github.com/bogdancanciu/frekathon-backend/handlers/protocol"
+	_ "github.com/bogdancanciu/frekathon-backend/migrations"
 	"github.com/labstack/echo/v5"
 	"github.com/pocketbase/pocketbase"
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/plugins/migratecmd"
 	"log"
 )
 
 func main() {
 	app := pocketbase.New()
+
+	migratecmd.MustRegister(app, app.RootCmd, migratecmd.Config{
+		// collections are defined by the Go migrations in ./migrations
+		Automigrate: false,
+	})
+
 	hub := protocol.NewHub(app)
 	go hub.Run()
 
@@ -21,8 +29,7 @@ func main() {
 			w := c.Response().Writer
 			if r.URL.Path == "/ws" {
 				// Handle WebSocket upgrade
-				protocol.ServeWs(app, hub, w, r)
-				return nil
+				return protocol.ServeWs(app, hub, w, r)
 			}
 			return next(c)
 		}
@@ -33,13 +40,19 @@ func main() {
 		return nil
 	})
 
+	app.RootCmd.AddCommand(handlers.NewRepairUsersCommand(app))
+	app.RootCmd.AddCommand(handlers.NewRepairTagsCommand(app))
+
+	handlers.BindSessionHooks(app)
 	handlers.BindRegisterHooks(app)
-	handlers.BindEventsHooks(app)
-	handlers.BindFriendsHooks(app)
+	handlers.BindEventsHooks(app, hub)
+	handlers.BindFriendsHooks(app, hub)
+	handlers.BindBlocksHooks(app)
 	handlers.BindInterestsHooks(app)
 	handlers.BindChatFinderHooks(app)
 	handlers.BindSearchFriendsHooks(app)
 	handlers.BindProfileHooks(app)
+	handlers.BindAccountHooks(app, hub)
 
 	if err := app.Start(); err != nil {
 		log.Fatal(err)
@@ -0,0 +1,362 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"golang.org/x/exp/slices"
+	"log"
+	"net/http"
+)
+
+const (
+	// HostedEventsTransfer hands every hosted event with other attendants
+	// over to the earliest of them, the others are cancelled.
+	HostedEventsTransfer = "transfer"
+	// HostedEventsCancel cancels every hosted event.
+	HostedEventsCancel = "cancel"
+
+	deletedUserName = "Deleted user"
+)
+
+func BindAccountHooks(app core.App, notifier Notifier) {
+	app.OnBeforeServe().Add(DeleteOwnAccount(app, notifier))
+	app.OnBeforeServe().Add(DeleteUserAccount(app, notifier))
+}
+
+func DeleteOwnAccount(app core.App, notifier Notifier) func(e *core.ServeEvent) error {
+	return func(e *core.ServeEvent) error {
+		apiRoutes(app, e).DELETE("/profile", func(c echo.Context) error {
+			hostedEvents, err := hostedEventsPolicy(c)
+			if err != nil {
+				return err
+			}
+
+			if err := DeleteAccount(app, notifier, CurrentUser(c).Id, hostedEvents); err != nil {
+				return err
+			}
+
+			return c.NoContent(http.StatusNoContent)
+		})
+		return nil
+	}
+}
+
+// DeleteUserAccount is the admin variant of DeleteOwnAccount.
+func DeleteUserAccount(app core.App, notifier Notifier) func(e *core.ServeEvent) error {
+	return func(e *core.ServeEvent) error {
+		e.Router.DELETE("/api/admin/users/:user_id", func(c echo.Context) error {
+			hostedEvents, err := hostedEventsPolicy(c)
+			if err != nil {
+				return err
+			}
+
+			if err := DeleteAccount(app, notifier, c.PathParam("user_id"), hostedEvents); err != nil {
+				return err
+			}
+
+			return c.NoContent(http.StatusNoContent)
+		}, apis.RequireAdminAuth())
+		return nil
+	}
+}
+
+func hostedEventsPolicy(c echo.Context) (string, *apis.ApiError) {
+	switch policy := c.QueryParam("events"); policy {
+	case "", HostedEventsTransfer:
+		return HostedEventsTransfer, nil
+	case HostedEventsCancel:
+		return HostedEventsCancel, nil
+	default:
+		return "", apis.NewBadRequestError("Events must be either transfer or cancel.", "")
+	}
+}
+
+// DeleteAccount deletes the user together with every record pointing at them,
+// in a single transaction. Live sockets are closed by the hub once the users
+// record is deleted. Waitlisted users promoted into the freed spots are
+// notified once it is committed.
+func DeleteAccount(app core.App, notifier Notifier, userId, hostedEvents string) *apis.ApiError {
+	var promoted map[*models.Record][]string
+
+	err := app.Dao().RunInTransaction(func(txDao *daos.Dao) error {
+		promoted = map[*models.Record][]string{}
+		userRecord, err := txDao.FindRecordById("users", userId)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return apis.NewNotFoundError("User not found.", "")
+			}
+			return err
+		}
+
+		return deleteAccount(txDao, userRecord, hostedEvents, promoted)
+	})
+
+	var apiErr *apis.ApiError
+	switch {
+	case err == nil:
+		for event, userIds := range promoted {
+			notifyPromoted(notifier, event, userIds)
+		}
+		return nil
+	case errors.As(err, &apiErr):
+		return apiErr
+	default:
+		log.Println("Failed to delete account", err)
+		return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+	}
+}
+
+// deleteAccount records in promoted the users moved from the waitlist of each
+// event the user left.
+func deleteAccount(txDao *daos.Dao, userRecord *models.Record, hostedEvents string, promoted map[*models.Record][]string) error {
+	userId := userRecord.Id
+
+	if err := releaseHostedEvents(txDao, userId, hostedEvents); err != nil {
+		return err
+	}
+
+	for _, field := range []string{"attendants", "waitlist"} {
+		events, err := findRecordsContaining(txDao, "events", field, userId)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err := removeFromList(txDao, event, field, userId); err != nil {
+				return err
+			}
+
+			promotedUsers, err := promoteWaitlisted(txDao, event)
+			if err != nil {
+				return err
+			}
+			if len(promotedUsers) > 0 {
+				promoted[event] = promotedUsers
+			}
+		}
+	}
+
+	invitedTo, err := findRecordsContaining(txDao, "events", "invited", userId)
+	if err != nil {
+		return err
+	}
+	for _, event := range invitedTo {
+		if err := removeFromList(txDao, event, "invited", userId); err != nil {
+			return err
+		}
+	}
+
+	friendships, err := txDao.FindRecordsByExpr("friendships",
+		dbx.Or(dbx.HashExp{"requester": userId}, dbx.HashExp{"addressee": userId}),
+	)
+	if err != nil {
+		return err
+	}
+	for _, friendship := range friendships {
+		if err := endFriendship(txDao, friendship); err != nil {
+			return err
+		}
+	}
+
+	chats, err := findRecordsContaining(txDao, "chats", "participants", userId)
+	if err != nil {
+		return err
+	}
+	chatIds := map[string]bool{}
+	otherParticipants := map[string]bool{}
+	for _, chat := range chats {
+		chatIds[chat.Id] = true
+		for _, participant := range chat.GetStringSlice("participants") {
+			if participant != userId {
+				otherParticipants[participant] = true
+			}
+		}
+
+		if isDirectChat(chat) {
+			chat.Set("archived", true)
+		}
+		if err := removeFromList(txDao, chat, "participants", userId); err != nil {
+			return err
+		}
+	}
+
+	aliases := []string{userRecord.GetString("name"), userRecord.GetString("tag")}
+	for participant := range otherParticipants {
+		if err := anonymizePendingMessages(txDao, participant, chatIds, aliases); err != nil {
+			return err
+		}
+	}
+
+	if err := removeLegacyFriends(txDao, userId); err != nil {
+		return err
+	}
+
+	if err := deleteChatFinderEntries(txDao, []string{userId}); err != nil {
+		return err
+	}
+
+	for _, collection := range append([]string{"friends"}, companionCollections...) {
+		records, err := txDao.FindRecordsByExpr(collection, dbx.HashExp{"user_id": userId})
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			if err := txDao.DeleteRecord(record); err != nil {
+				return err
+			}
+		}
+	}
+
+	// friendships and blocks go away through their cascading relations
+	return txDao.DeleteRecord(userRecord)
+}
+
+// releaseHostedEvents transfers or cancels the events the user created.
+func releaseHostedEvents(txDao *daos.Dao, userId, policy string) error {
+	events, err := txDao.FindRecordsByExpr("events", dbx.HashExp{"user_id": userId})
+	if err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		attendants := slices.DeleteFunc(event.GetStringSlice("attendants"), func(id string) bool {
+			return id == userId
+		})
+
+		if policy == HostedEventsTransfer && len(attendants) > 0 {
+			event.Set("user_id", attendants[0])
+			event.Set("attendants", attendants)
+			if err := txDao.SaveRecord(event); err != nil {
+				return err
+			}
+			continue
+		}
+
+		attending, err := findRecordsContaining(txDao, "attending_events", "attending_events", event.Id)
+		if err != nil {
+			return err
+		}
+		for _, record := range attending {
+			if err := removeFromList(txDao, record, "attending_events", event.Id); err != nil {
+				return err
+			}
+		}
+
+		if err := txDao.DeleteRecord(event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// anonymizePendingMessages replaces the sender of the messages queued for
+// the participant in the given chats when it is one of the aliases of the
+// deleted user.
+func anonymizePendingMessages(txDao *daos.Dao, participant string, chatIds map[string]bool, aliases []string) error {
+	messagesRecord, err := txDao.FindFirstRecordByData("messages", "user_id", participant)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	var pending [][]byte
+	if raw, ok := messagesRecord.Get("messages").(types.JsonRaw); ok && len(raw) > 0 {
+		if err := json.Unmarshal(raw, &pending); err != nil {
+			return err
+		}
+	}
+
+	changed := false
+	for i, rawMessage := range pending {
+		var message map[string]any
+		if err := json.Unmarshal(rawMessage, &message); err != nil {
+			continue
+		}
+
+		chatId, _ := message["chat_id"].(string)
+		sender, _ := message["sender"].(string)
+		if !chatIds[chatId] || !slices.Contains(aliases, sender) {
+			continue
+		}
+
+		message["sender"] = deletedUserName
+		if pending[i], err = json.Marshal(message); err != nil {
+			return err
+		}
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+
+	messagesRecord.Set("messages", pending)
+	return txDao.SaveRecord(messagesRecord)
+}
+
+// removeLegacyFriends drops the user from the lists of the friends collection
+// still kept around from before friendships.
+func removeLegacyFriends(txDao *daos.Dao, userId string) error {
+	friendsRecords, err := txDao.FindRecordsByExpr("friends",
+		dbx.Not(dbx.HashExp{"user_id": userId}),
+		dbx.Or(
+			dbx.Like("friend_list", userId),
+			dbx.Like("pending_list", userId),
+			dbx.Like("sent_invites", userId),
+		),
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, friendsRecord := range friendsRecords {
+		changed := false
+		for _, field := range []string{"friend_list", "pending_list", "sent_invites"} {
+			var entries []map[string]any
+			if raw, ok := friendsRecord.Get(field).(types.JsonRaw); ok && len(raw) > 0 {
+				if err := json.Unmarshal(raw, &entries); err != nil {
+					return err
+				}
+			}
+
+			kept := slices.DeleteFunc(entries, func(entry map[string]any) bool {
+				return entry["id"] == userId
+			})
+			if len(kept) != len(entries) {
+				friendsRecord.Set(field, kept)
+				changed = true
+			}
+		}
+
+		if changed {
+			if err := txDao.SaveRecord(friendsRecord); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func removeFromList(txDao *daos.Dao, record *models.Record, field, value string) error {
+	list := slices.DeleteFunc(record.GetStringSlice(field), func(item string) bool {
+		return item == value
+	})
+	record.Set(field, list)
+
+	return txDao.SaveRecord(record)
+}
+
+func isDirectChat(chat *models.Record) bool {
+	return chat.GetString("type") == "dm"
+}
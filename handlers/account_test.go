@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDeleteAccount(t *testing.T) {
+	app := newTestApp(t)
+	user := newTestUser(t, app)
+	friend := newTestUser(t, app)
+	other := newTestUser(t, app)
+	for _, userId := range []string{user.Id, friend.Id, other.Id} {
+		assert.NoError(t, provisionUser(app.Dao(), userId))
+	}
+
+	assert.NoError(t, runFriendsTransaction(app, func(txDao *daos.Dao) error {
+		if err := sendInvite(txDao, user.Id, friend.Id); err != nil {
+			return err
+		}
+		if err := acceptInvite(txDao, friend.Id, user.Id); err != nil {
+			return err
+		}
+		return addBlock(txDao, user.Id, other.Id, BlockKindMute)
+	}))
+	friendship, err := findFriendship(app.Dao(), user.Id, friend.Id)
+	assert.NoError(t, err)
+	chatId := friendship.GetString("chat_id")
+
+	insertEvent := func(eventId, creator string, attendants ...string) {
+		rawAttendants, _ := json.Marshal(attendants)
+		_, err := app.Dao().DB().Insert("events", dbx.Params{
+			"id":         eventId,
+			"user_id":    creator,
+			"name":       eventId,
+			"attendants": string(rawAttendants),
+		}).Execute()
+		assert.NoError(t, err)
+	}
+	insertEvent("transferred", user.Id, user.Id, other.Id)
+	insertEvent("cancelled", user.Id, user.Id)
+	insertEvent("attended", friend.Id, friend.Id, user.Id)
+	insertEvent("invited", friend.Id, friend.Id)
+	invitedEvent, err := app.Dao().FindRecordById("events", "invited")
+	assert.NoError(t, err)
+	invitedEvent.Set("invited", []string{user.Id, other.Id})
+	assert.NoError(t, app.Dao().SaveRecord(invitedEvent))
+
+	pending, _ := json.Marshal(map[string]any{"chat_id": chatId, "sender": "Test User", "message": "hi"})
+	messagesRecord, err := FindCompanionRecord(app.Dao(), "messages", friend.Id)
+	assert.NoError(t, err)
+	messagesRecord.Set("messages", [][]byte{pending})
+	assert.NoError(t, app.Dao().SaveRecord(messagesRecord))
+
+	assert.Nil(t, DeleteAccount(app, &testNotifier{}, user.Id, HostedEventsTransfer))
+
+	t.Run("should delete the user and their own records", func(t *testing.T) {
+		_, err := app.Dao().FindRecordById("users", user.Id)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		for _, collection := range companionCollections {
+			_, err := app.Dao().FindFirstRecordByData(collection, "user_id", user.Id)
+			assert.ErrorIs(t, err, sql.ErrNoRows)
+		}
+
+		_, err = findFriendship(app.Dao(), user.Id, friend.Id)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		blocked, err := blockTargets(app.Dao(), user.Id, BlockKindMute)
+		assert.NoError(t, err)
+		assert.Empty(t, blocked)
+	})
+
+	t.Run("should transfer or cancel hosted events", func(t *testing.T) {
+		transferred, err := app.Dao().FindRecordById("events", "transferred")
+		if assert.NoError(t, err) {
+			assert.Equal(t, other.Id, transferred.GetString("user_id"))
+			assert.Equal(t, []string{other.Id}, transferred.GetStringSlice("attendants"))
+		}
+
+		_, err = app.Dao().FindRecordById("events", "cancelled")
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		attended, err := app.Dao().FindRecordById("events", "attended")
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{friend.Id}, attended.GetStringSlice("attendants"))
+		}
+
+		invited, err := app.Dao().FindRecordById("events", "invited")
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{other.Id}, invited.GetStringSlice("invited"))
+		}
+	})
+
+	t.Run("should archive shared chats and anonymize pending messages", func(t *testing.T) {
+		chat, err := app.Dao().FindRecordById("chats", chatId)
+		if assert.NoError(t, err) {
+			assert.True(t, chat.GetBool("archived"))
+			assert.Equal(t, []string{friend.Id}, chat.GetStringSlice("participants"))
+		}
+
+		messagesRecord, err := FindCompanionRecord(app.Dao(), "messages", friend.Id)
+		assert.NoError(t, err)
+		var messages [][]byte
+		assert.NoError(t, json.Unmarshal(messagesRecord.Get("messages").(types.JsonRaw), &messages))
+		if assert.Len(t, messages, 1) {
+			assert.Contains(t, string(messages[0]), `"sender":"`+deletedUserName+`"`)
+		}
+	})
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+)
+
+const ContextUserKey = "sessionUser"
+
+// RequireSession authenticates the request session token and loads the
+// matching users record into the request context.
+func RequireSession(app core.App) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			userRecord, err := UserFromSession(app, GetSessionToken(c.Request()))
+			if err != nil {
+				return err
+			}
+
+			c.Set(ContextUserKey, userRecord)
+			return next(c)
+		}
+	}
+}
+
+// CurrentUser returns the users record loaded by RequireSession, or nil when
+// the request went through an unauthenticated route.
+func CurrentUser(c echo.Context) *models.Record {
+	userRecord, _ := c.Get(ContextUserKey).(*models.Record)
+	return userRecord
+}
+
+// apiRoutes returns the router group for the custom /api endpoints.
+// Every route registered on it requires an authenticated session.
+func apiRoutes(app core.App, e *core.ServeEvent) *echo.Group {
+	return e.Router.Group("/api", RequireSession(app))
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/tokens"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireSession(t *testing.T) {
+	app := newTestApp(t)
+	user := newTestUser(t, app)
+	token, err := tokens.NewRecordAuthToken(app, user)
+	assert.NoError(t, err)
+
+	handler := RequireSession(app)(func(c echo.Context) error {
+		return c.String(http.StatusOK, CurrentUser(c).Id)
+	})
+
+	serve := func(header, value string) (*httptest.ResponseRecorder, error) {
+		req := httptest.NewRequest(http.MethodGet, "/api/profile", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rec := httptest.NewRecorder()
+
+		return rec, handler(echo.New().NewContext(req, rec))
+	}
+
+	t.Run("should load user from session-token header", func(t *testing.T) {
+		rec, err := serve("session-token", token)
+		assert.NoError(t, err)
+		assert.Equal(t, user.Id, rec.Body.String())
+	})
+
+	t.Run("should load user from Authorization header", func(t *testing.T) {
+		rec, err := serve("Authorization", "Bearer "+token)
+		assert.NoError(t, err)
+		assert.Equal(t, user.Id, rec.Body.String())
+	})
+
+	t.Run("should reject request without a session", func(t *testing.T) {
+		_, err := serve("", "")
+		apiErr, ok := err.(*apis.ApiError)
+		assert.True(t, ok)
+		assert.Equal(t, http.StatusUnauthorized, apiErr.Code)
+	})
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"net/http"
+)
+
+const (
+	// BlockKindBlock hides both users from each other: no invites, no events,
+	// no messages and no shared anonymous chats.
+	BlockKindBlock = "block"
+	// BlockKindMute only stops the target's messages from being delivered.
+	BlockKindMute = "mute"
+)
+
+type blockList struct {
+	Blocked []string `json:"blocked"`
+	Muted   []string `json:"muted"`
+}
+
+func BindBlocksHooks(app core.App) {
+	app.OnBeforeServe().Add(GetBlocks(app))
+	app.OnBeforeServe().Add(BlockUser(app, BlockKindBlock, "/blocks/:user_id"))
+	app.OnBeforeServe().Add(UnblockUser(app, BlockKindBlock, "/blocks/:user_id"))
+	app.OnBeforeServe().Add(BlockUser(app, BlockKindMute, "/mutes/:user_id"))
+	app.OnBeforeServe().Add(UnblockUser(app, BlockKindMute, "/mutes/:user_id"))
+}
+
+func GetBlocks(app core.App) func(e *core.ServeEvent) error {
+	return func(e *core.ServeEvent) error {
+		apiRoutes(app, e).GET("/blocks", func(c echo.Context) error {
+			userId := CurrentUser(c).Id
+
+			blocked, err := blockTargets(app.Dao(), userId, BlockKindBlock)
+			if err != nil {
+				return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+			}
+
+			muted, err := blockTargets(app.Dao(), userId, BlockKindMute)
+			if err != nil {
+				return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+			}
+
+			return c.JSON(http.StatusOK, blockList{Blocked: blocked, Muted: muted})
+		})
+		return nil
+	}
+}
+
+func BlockUser(app core.App, kind, path string) func(e *core.ServeEvent) error {
+	return func(e *core.ServeEvent) error {
+		apiRoutes(app, e).PUT(path, func(c echo.Context) error {
+			userId := CurrentUser(c).Id
+			targetId := c.PathParam("user_id")
+			if targetId == userId {
+				return apis.NewBadRequestError("You can't "+kind+" yourself.", "")
+			}
+
+			err := runFriendsTransaction(app, func(txDao *daos.Dao) error {
+				return addBlock(txDao, userId, targetId, kind)
+			})
+			if err != nil {
+				return err
+			}
+
+			return c.NoContent(http.StatusOK)
+		})
+		return nil
+	}
+}
+
+func UnblockUser(app core.App, kind, path string) func(e *core.ServeEvent) error {
+	return func(e *core.ServeEvent) error {
+		apiRoutes(app, e).DELETE(path, func(c echo.Context) error {
+			userId := CurrentUser(c).Id
+			targetId := c.PathParam("user_id")
+
+			block, err := findBlock(app.Dao(), userId, targetId, kind)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return c.NoContent(http.StatusOK)
+				}
+				return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+			}
+
+			if err := app.Dao().DeleteRecord(block); err != nil {
+				return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+			}
+
+			return c.NoContent(http.StatusOK)
+		})
+		return nil
+	}
+}
+
+// addBlock records the block or mute. Blocking also ends any friendship or
+// pending invitation between the two users.
+func addBlock(txDao *daos.Dao, userId, targetId, kind string) error {
+	if _, err := txDao.FindRecordById("users", targetId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return apis.NewNotFoundError("User not found.", "")
+		}
+		return err
+	}
+
+	if _, err := findBlock(txDao, userId, targetId, kind); err == nil {
+		return nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	collection, err := txDao.FindCollectionByNameOrId("user_blocks")
+	if err != nil {
+		return err
+	}
+
+	block := models.NewRecord(collection)
+	block.Set("user", userId)
+	block.Set("target", targetId)
+	block.Set("kind", kind)
+	if err := txDao.SaveRecord(block); err != nil {
+		return err
+	}
+
+	if kind != BlockKindBlock {
+		return nil
+	}
+
+	friendship, err := findFriendship(txDao, userId, targetId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	return endFriendship(txDao, friendship)
+}
+
+func findBlock(dao *daos.Dao, userId, targetId, kind string) (*models.Record, error) {
+	return dao.FindFirstRecordByFilter(
+		"user_blocks",
+		"user = {:user} && target = {:target} && kind = {:kind}",
+		dbx.Params{"user": userId, "target": targetId, "kind": kind},
+	)
+}
+
+// blockTargets returns the IDs of the users the user blocked or muted.
+func blockTargets(dao *daos.Dao, userId, kind string) ([]string, error) {
+	ids := []string{}
+	err := dao.DB().
+		Select("target").
+		From("user_blocks").
+		Where(dbx.HashExp{"user": userId, "kind": kind}).
+		OrderBy("created ASC").
+		Column(&ids)
+
+	return ids, err
+}
+
+// blockedIds returns the IDs of the users the user blocked or was blocked by.
+func blockedIds(dao *daos.Dao, userId string) ([]string, error) {
+	var blocks []struct {
+		User   string `db:"user"`
+		Target string `db:"target"`
+	}
+	err := dao.DB().
+		Select("user", "target").
+		From("user_blocks").
+		Where(dbx.HashExp{"kind": BlockKindBlock}).
+		AndWhere(dbx.Or(dbx.HashExp{"user": userId}, dbx.HashExp{"target": userId})).
+		All(&blocks)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		if block.User == userId {
+			ids = append(ids, block.Target)
+		} else {
+			ids = append(ids, block.User)
+		}
+	}
+
+	return ids, nil
+}
+
+// isBlocked reports whether either user blocked the other.
+func isBlocked(dao *daos.Dao, userId, otherId string) (bool, error) {
+	var count int
+	err := dao.DB().
+		Select("COUNT(*)").
+		From("user_blocks").
+		Where(dbx.HashExp{"kind": BlockKindBlock}).
+		AndWhere(dbx.Or(
+			dbx.HashExp{"user": userId, "target": otherId},
+			dbx.HashExp{"user": otherId, "target": userId},
+		)).
+		Row(&count)
+
+	return count > 0, err
+}
+
+// IsSilenced reports whether messages from sender must not be delivered to
+// recipient, because either of them blocked the other or recipient muted
+// sender.
+func IsSilenced(dao *daos.Dao, recipientId, senderId string) (bool, error) {
+	var count int
+	err := dao.DB().
+		Select("COUNT(*)").
+		From("user_blocks").
+		Where(dbx.Or(
+			dbx.HashExp{"user": recipientId, "target": senderId},
+			dbx.HashExp{"user": senderId, "target": recipientId, "kind": BlockKindBlock},
+		)).
+		Row(&count)
+
+	return count > 0, err
+}
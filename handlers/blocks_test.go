@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"database/sql"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestBlocks(t *testing.T) {
+	app := newTestApp(t)
+	user := newTestUser(t, app)
+	friend := newTestUser(t, app)
+	other := newTestUser(t, app)
+
+	run := func(fn func(txDao *daos.Dao) error) error {
+		return runFriendsTransaction(app, fn)
+	}
+
+	t.Run("should only silence chat delivery when muting", func(t *testing.T) {
+		assert.NoError(t, run(func(txDao *daos.Dao) error {
+			return addBlock(txDao, user.Id, other.Id, BlockKindMute)
+		}))
+
+		silenced, err := IsSilenced(app.Dao(), user.Id, other.Id)
+		assert.NoError(t, err)
+		assert.True(t, silenced)
+
+		silenced, err = IsSilenced(app.Dao(), other.Id, user.Id)
+		assert.NoError(t, err)
+		assert.False(t, silenced)
+
+		assert.NoError(t, run(func(txDao *daos.Dao) error {
+			return sendInvite(txDao, other.Id, user.Id)
+		}))
+		assertFriendshipStatus(t, app, user.Id, other.Id, FriendshipPending)
+	})
+
+	t.Run("should end the friendship and refuse invites when blocking", func(t *testing.T) {
+		assert.NoError(t, run(func(txDao *daos.Dao) error {
+			if err := sendInvite(txDao, user.Id, friend.Id); err != nil {
+				return err
+			}
+			return acceptInvite(txDao, friend.Id, user.Id)
+		}))
+
+		assert.NoError(t, run(func(txDao *daos.Dao) error {
+			return addBlock(txDao, friend.Id, user.Id, BlockKindBlock)
+		}))
+
+		_, err := findFriendship(app.Dao(), user.Id, friend.Id)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		assertApiErrorCode(t, http.StatusForbidden, run(func(txDao *daos.Dao) error {
+			return sendInvite(txDao, user.Id, friend.Id)
+		}))
+
+		for _, pair := range [][2]string{{user.Id, friend.Id}, {friend.Id, user.Id}} {
+			silenced, err := IsSilenced(app.Dao(), pair[0], pair[1])
+			assert.NoError(t, err)
+			assert.True(t, silenced)
+		}
+
+		ids, err := blockedIds(app.Dao(), user.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{friend.Id}, ids)
+	})
+
+	t.Run("should block idempotently", func(t *testing.T) {
+		assert.NoError(t, run(func(txDao *daos.Dao) error {
+			return addBlock(txDao, friend.Id, user.Id, BlockKindBlock)
+		}))
+
+		blocked, err := blockTargets(app.Dao(), friend.Id, BlockKindBlock)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{user.Id}, blocked)
+	})
+}
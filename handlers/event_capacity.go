@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	AttendanceAttending  = "attending"
+	AttendanceWaitlisted = "waitlisted"
+)
+
+// Notifier delivers a notification to the user, the hub queues it while they
+// are offline.
+type Notifier interface {
+	Notify(userId, message string)
+}
+
+type attendance struct {
+	Status           string `json:"status"`
+	WaitlistPosition int    `json:"waitlist_position,omitempty"`
+}
+
+// fillAttendance computes the attendance fields shown to the user.
+func (e *eventRecord) fillAttendance(userId string) {
+	e.AttendantsCount = len(e.Attendants)
+
+	if e.MaxAttendants > 0 {
+		spotsLeft := max(e.MaxAttendants-len(e.Attendants), 0)
+		e.SpotsLeft = &spotsLeft
+	}
+
+	e.WaitlistPosition = slices.Index(e.Waitlist, userId) + 1
+}
+
+// isFull reports whether the event has a limit its attendants reached.
+func isFull(event *models.Record) bool {
+	maxAttendants := event.GetInt("max_attendants")
+	return maxAttendants > 0 && len(event.GetStringSlice("attendants")) >= maxAttendants
+}
+
+// promoteWaitlisted moves users from the head of the waitlist to the
+// attendants while spots are left and returns them.
+func promoteWaitlisted(txDao *daos.Dao, event *models.Record) ([]string, error) {
+	var promoted []string
+
+	waitlist := event.GetStringSlice("waitlist")
+	attendants := event.GetStringSlice("attendants")
+	for len(waitlist) > 0 && !isFull(event) {
+		promoted = append(promoted, waitlist[0])
+		attendants = append(attendants, waitlist[0])
+		waitlist = waitlist[1:]
+
+		event.Set("attendants", attendants)
+		event.Set("waitlist", waitlist)
+	}
+
+	if len(promoted) == 0 {
+		return nil, nil
+	}
+
+	if err := txDao.SaveRecord(event); err != nil {
+		return nil, err
+	}
+
+	for _, userId := range promoted {
+		if err := addAttendingEvent(txDao, userId, event.Id); err != nil {
+			return nil, err
+		}
+	}
+
+	return promoted, nil
+}
+
+// setEventCapacity changes the attendants limit of the creator's event, 0
+// meaning unlimited, and promotes waitlisted users to the spots it opens.
+// Lowering the limit keeps the current attendants.
+func setEventCapacity(app core.App, notifier Notifier, userId, eventId string, maxAttendants int) *apis.ApiError {
+	var event *models.Record
+	var promoted []string
+
+	err := runEventsTransaction(app, func(txDao *daos.Dao) error {
+		var err error
+		event, err = findEvent(txDao, eventId)
+		if err != nil {
+			return err
+		}
+
+		if event.GetString("user_id") != userId {
+			return apis.NewForbiddenError("Only the creator can change the capacity of the event.", "")
+		}
+
+		event.Set("max_attendants", maxAttendants)
+		if err := txDao.SaveRecord(event); err != nil {
+			return err
+		}
+
+		promoted, err = promoteWaitlisted(txDao, event)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	notifyPromoted(notifier, event, promoted)
+
+	return nil
+}
+
+// fillOpenSpots promotes waitlisted users to the spots left in the event.
+func fillOpenSpots(app core.App, notifier Notifier, eventId string) *apis.ApiError {
+	var event *models.Record
+	var promoted []string
+
+	err := runEventsTransaction(app, func(txDao *daos.Dao) error {
+		var err error
+		event, err = findEvent(txDao, eventId)
+		if err != nil {
+			return err
+		}
+
+		promoted, err = promoteWaitlisted(txDao, event)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	notifyPromoted(notifier, event, promoted)
+
+	return nil
+}
+
+func notifyPromoted(notifier Notifier, event *models.Record, promoted []string) {
+	for _, userId := range promoted {
+		notifier.Notify(userId, "A spot opened up, you're now attending "+event.GetString("name")+".")
+	}
+}
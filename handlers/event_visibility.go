@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"github.com/pocketbase/pocketbase/daos"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	EventVisibilityFriends          = "friends"
+	EventVisibilityFriendsOfFriends = "friends_of_friends"
+	EventVisibilityPublic           = "public"
+	EventVisibilityInviteOnly       = "invite_only"
+)
+
+// eventViewer holds what decides which events a user may see and attend.
+type eventViewer struct {
+	userId           string
+	friends          map[string]bool
+	friendsOfFriends map[string]bool
+	blocked          map[string]bool
+}
+
+func newEventViewer(dao *daos.Dao, userId string) (*eventViewer, error) {
+	friends, err := friendIds(dao, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	friendsOfFriendsIds, err := friendsOfFriends(dao, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	blocked, err := blockedIds(dao, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &eventViewer{
+		userId:           userId,
+		friends:          toSet(friends),
+		friendsOfFriends: toSet(friendsOfFriendsIds),
+		blocked:          toSet(blocked),
+	}, nil
+}
+
+// canSee reports whether the user may see the event, and so attend it.
+// Creators, attendants and waitlisted users always see it, invited users see
+// it whatever its visibility, and users blocking each other never see each
+// other's events.
+func (v *eventViewer) canSee(event eventRecord) bool {
+	switch {
+	case event.UserId == v.userId:
+		return true
+	case v.blocked[event.UserId]:
+		return false
+	case slices.Contains(event.Attendants, v.userId), slices.Contains(event.Waitlist, v.userId),
+		slices.Contains(event.Invited, v.userId):
+		return true
+	}
+
+	switch event.Visibility {
+	case EventVisibilityPublic:
+		return true
+	case EventVisibilityFriendsOfFriends:
+		return v.friends[event.UserId] || v.friendsOfFriends[event.UserId]
+	case EventVisibilityInviteOnly:
+		return false
+	default:
+		return v.friends[event.UserId]
+	}
+}
+
+// canAttend reports whether the user sees the event, neither attends nor
+// waits for it yet and a spot is left.
+func (v *eventViewer) canAttend(event eventRecord) bool {
+	return v.canSee(event) &&
+		!slices.Contains(event.Attendants, v.userId) &&
+		!slices.Contains(event.Waitlist, v.userId) &&
+		(event.MaxAttendants == 0 || len(event.Attendants) < event.MaxAttendants)
+}
+
+func toSet(ids []string) map[string]bool {
+	set := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+
+	return set
+}
@@ -1,15 +1,18 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"github.com/labstack/echo/v5"
 	"github.com/pocketbase/pocketbase/apis"
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
 	"github.com/pocketbase/pocketbase/tools/types"
 	"golang.org/x/exp/slices"
+	"log"
 	"net/http"
-	"strings"
 )
 
 type eventResponse struct {
@@ -20,20 +23,29 @@ type eventResponse struct {
 
 type eventRecord struct {
 	ID              string                  `db:"id" json:"id"`
+	UserId          string                  `db:"user_id" json:"user_id"`
 	Title           string                  `db:"name" json:"title"`
 	Location        string                  `db:"location" json:"location"`
 	Date            string                  `db:"date" json:"date"`
 	Description     string                  `db:"description" json:"description"`
 	Emoji           string                  `db:"emoji" json:"emoji"`
+	Visibility      string                  `db:"visibility" json:"visibility"`
 	Attendants      types.JsonArray[string] `db:"attendants" json:"attendants"`
+	Invited         types.JsonArray[string] `db:"invited" json:"-"`
+	MaxAttendants   int                     `db:"max_attendants" json:"max_attendants,omitempty"`
+	Waitlist        types.JsonArray[string] `db:"waitlist" json:"-"`
 	AttendantsCount int                     `json:"attendants_count"`
-	CanAttend       bool                    `json:"can_attend"`
+	// SpotsLeft is left out for events without a limit.
+	SpotsLeft *int `json:"spots_left,omitempty"`
+	// WaitlistPosition starts at 1, 0 meaning the user isn't waitlisted.
+	WaitlistPosition int  `json:"waitlist_position,omitempty"`
+	CanAttend        bool `json:"can_attend"`
 }
 
-func BindEventsHooks(app core.App) {
+func BindEventsHooks(app core.App, notifier Notifier) {
 	app.OnRecordBeforeCreateRequest("events").Add(func(e *core.RecordCreateEvent) error {
-		sessionToken := getSessionToken(e.HttpContext.Request())
-		userId, err := UserIdFromSession(sessionToken)
+		sessionToken := GetSessionToken(e.HttpContext.Request())
+		userId, err := UserIdFromSession(app, sessionToken)
 		if err != nil {
 			return err
 		}
@@ -42,79 +54,317 @@ func BindEventsHooks(app core.App) {
 
 		e.Record.Set("user_id", userId)
 		e.Record.Set("attendants", attendants)
+		if e.Record.GetString("visibility") == "" {
+			e.Record.Set("visibility", EventVisibilityFriends)
+		}
+		e.Record.Set("invited", e.Record.GetStringSlice("invited"))
+		e.Record.Set("waitlist", []string{})
+
+		// clients predating max_attendants still send the capacity as limit,
+		// which isn't a field anymore
+		data := apis.RequestInfo(e.HttpContext).Data
+		if _, ok := data["max_attendants"]; !ok && data["limit"] != nil {
+			e.Record.Set("max_attendants", data["limit"])
+			e.Record.Set("max_attendants", max(e.Record.GetInt("max_attendants"), 0))
+		}
+
 		if err := app.Dao().SaveRecord(e.Record); err != nil {
 			return apis.NewApiError(http.StatusInternalServerError, "Failed to create event.", "")
 		}
 
 		return nil
 	})
+	app.OnModelAfterUpdate("events").Add(PromoteOnCapacityChange(app, notifier))
+	app.OnBeforeServe().Add(GetEvent(app))
 	app.OnBeforeServe().Add(AttendEvent(app))
+	app.OnBeforeServe().Add(LeaveEvent(app, notifier))
+	app.OnBeforeServe().Add(SetEventCapacity(app, notifier))
 	app.OnBeforeServe().Add(GetEvents(app))
 }
 
-func AttendEvent(app core.App) func(e *core.ServeEvent) error {
+// PromoteOnCapacityChange fills the spots an update opened with waitlisted
+// users. The events API can't change attendance, but admins can raise the
+// capacity of an event from the dashboard.
+func PromoteOnCapacityChange(app core.App, notifier Notifier) func(e *core.ModelEvent) error {
+	return func(e *core.ModelEvent) error {
+		event, ok := e.Model.(*models.Record)
+		if !ok || len(event.GetStringSlice("waitlist")) == 0 || isFull(event) {
+			return nil
+		}
+
+		if err := fillOpenSpots(app, notifier, event.Id); err != nil {
+			log.Println("Failed to promote waitlisted users of event", event.Id, err)
+		}
+		return nil
+	}
+}
+
+func GetEvent(app core.App) func(e *core.ServeEvent) error {
 	return func(e *core.ServeEvent) error {
-		e.Router.POST("/api/events/:event_id", func(c echo.Context) error {
-			session := getSessionToken(c.Request())
-			userId, sessionErr := UserIdFromSession(session)
-			if sessionErr != nil {
-				return sessionErr
-			}
+		apiRoutes(app, e).GET("/events/:event_id", func(c echo.Context) error {
+			userId := CurrentUser(c).Id
 
-			eventId := c.PathParam("event_id")
-			record, err := app.Dao().FindRecordById("events", eventId)
+			record, err := app.Dao().FindRecordById("events", c.PathParam("event_id"))
 			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return apis.NewNotFoundError("Event not found.", "")
+				}
 				return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
 			}
 
-			var attendantsSlice []string
-			attendants := record.Get("attendants").(types.JsonRaw)
-			err = json.Unmarshal(attendants, &attendantsSlice)
+			viewer, err := newEventViewer(app.Dao(), userId)
 			if err != nil {
+				log.Println("Failed to check event visibility", err)
 				return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
 			}
 
-			attendantsSlice = append(attendantsSlice, userId)
-			record.Set("attendants", attendantsSlice)
-			if err := app.Dao().SaveRecord(record); err != nil {
-				return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+			// hidden events are reported like missing ones
+			event := newEventRecord(record)
+			if !viewer.canSee(event) {
+				return apis.NewNotFoundError("Event not found.", "")
 			}
 
-			attendingEventsRecord, err := app.Dao().FindFirstRecordByData("attending_events", "user_id", userId)
+			event.fillAttendance(userId)
+			event.CanAttend = viewer.canAttend(event)
+
+			return c.JSON(http.StatusOK, event)
+		})
+		return nil
+	}
+}
+
+func AttendEvent(app core.App) func(e *core.ServeEvent) error {
+	return func(e *core.ServeEvent) error {
+		apiRoutes(app, e).POST("/events/:event_id", func(c echo.Context) error {
+			joinWaitlist := c.QueryParam("waitlist") == "true"
+
+			result, err := attendEvent(app, CurrentUser(c).Id, c.PathParam("event_id"), joinWaitlist)
 			if err != nil {
-				return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+				return err
 			}
 
-			var attendingEvents []string
-			attending := attendingEventsRecord.Get("attending_events").(types.JsonRaw)
-			err = json.Unmarshal(attending, &attendingEvents)
+			return c.JSON(http.StatusOK, result)
+		})
+		return nil
+	}
+}
+
+func LeaveEvent(app core.App, notifier Notifier) func(e *core.ServeEvent) error {
+	return func(e *core.ServeEvent) error {
+		apiRoutes(app, e).DELETE("/events/:event_id/attendance", func(c echo.Context) error {
+			if err := leaveEvent(app, notifier, CurrentUser(c).Id, c.PathParam("event_id")); err != nil {
+				return err
+			}
+
+			return c.NoContent(http.StatusNoContent)
+		})
+		return nil
+	}
+}
+
+func SetEventCapacity(app core.App, notifier Notifier) func(e *core.ServeEvent) error {
+	return func(e *core.ServeEvent) error {
+		apiRoutes(app, e).PUT("/events/:event_id/capacity", func(c echo.Context) error {
+			reqBody, err := readBody(c.Request())
 			if err != nil {
+				log.Println("Failed to read request body", err)
 				return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
 			}
 
-			attendingEvents = append(attendingEvents, eventId)
+			var bodyData struct {
+				MaxAttendants *int `json:"max_attendants"`
+			}
+			if err := json.Unmarshal(reqBody, &bodyData); err != nil || bodyData.MaxAttendants == nil {
+				return apis.NewBadRequestError("Malformed body.", "")
+			}
+			if *bodyData.MaxAttendants < 0 {
+				return apis.NewBadRequestError("Max attendants can't be negative, use 0 for no limit.", "")
+			}
 
-			attendingEventsRecord.Set("attending_events", attendingEvents)
-			if err := app.Dao().SaveRecord(attendingEventsRecord); err != nil {
-				return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+			apiErr := setEventCapacity(app, notifier, CurrentUser(c).Id, c.PathParam("event_id"), *bodyData.MaxAttendants)
+			if apiErr != nil {
+				return apiErr
 			}
 
-			return c.NoContent(http.StatusOK)
+			return c.NoContent(http.StatusNoContent)
 		})
 		return nil
 	}
 }
 
+// attendEvent adds the user to the attendants of the event and the event to
+// their attending events. When the event is full the user is put at the end
+// of the waitlist if they asked for it. Attending twice changes nothing.
+func attendEvent(app core.App, userId, eventId string, joinWaitlist bool) (*attendance, *apis.ApiError) {
+	result := &attendance{Status: AttendanceAttending}
+
+	err := runEventsTransaction(app, func(txDao *daos.Dao) error {
+		event, err := findEvent(txDao, eventId)
+		if err != nil {
+			return err
+		}
+
+		// creators attend from the start and list the event as their own
+		if event.GetString("user_id") == userId {
+			return nil
+		}
+
+		viewer, err := newEventViewer(txDao, userId)
+		if err != nil {
+			return err
+		}
+		if !viewer.canSee(newEventRecord(event)) {
+			return apis.NewForbiddenError("You can't attend this event.", "")
+		}
+
+		attendants := event.GetStringSlice("attendants")
+		waitlist := event.GetStringSlice("waitlist")
+		switch {
+		case slices.Contains(attendants, userId):
+		case slices.Contains(waitlist, userId):
+			result.Status = AttendanceWaitlisted
+			result.WaitlistPosition = slices.Index(waitlist, userId) + 1
+			return nil
+		case isFull(event):
+			if !joinWaitlist {
+				return apis.NewApiError(http.StatusConflict, "Event is full.", "")
+			}
+
+			event.Set("waitlist", append(waitlist, userId))
+			result.Status = AttendanceWaitlisted
+			result.WaitlistPosition = len(waitlist) + 1
+			return txDao.SaveRecord(event)
+		default:
+			event.Set("attendants", append(attendants, userId))
+			if err := txDao.SaveRecord(event); err != nil {
+				return err
+			}
+		}
+
+		return addAttendingEvent(txDao, userId, eventId)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// leaveEvent removes the user from the attendants or the waitlist of the
+// event and the event from their attending events, then promotes waitlisted
+// users into the freed spot. The creator can't leave their own event.
+func leaveEvent(app core.App, notifier Notifier, userId, eventId string) *apis.ApiError {
+	var event *models.Record
+	var promoted []string
+
+	err := runEventsTransaction(app, func(txDao *daos.Dao) error {
+		var err error
+		event, err = findEvent(txDao, eventId)
+		if err != nil {
+			return err
+		}
+
+		if event.GetString("user_id") == userId {
+			return apis.NewForbiddenError("You can't leave your own event.", "")
+		}
+
+		for _, field := range []string{"attendants", "waitlist"} {
+			if slices.Contains(event.GetStringSlice(field), userId) {
+				if err := removeFromList(txDao, event, field, userId); err != nil {
+					return err
+				}
+			}
+		}
+
+		attendingEventsRecord, err := FindCompanionRecord(txDao, "attending_events", userId)
+		if err != nil {
+			return err
+		}
+
+		if slices.Contains(attendingEventsRecord.GetStringSlice("attending_events"), eventId) {
+			if err := removeFromList(txDao, attendingEventsRecord, "attending_events", eventId); err != nil {
+				return err
+			}
+		}
+
+		promoted, err = promoteWaitlisted(txDao, event)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	notifyPromoted(notifier, event, promoted)
+
+	return nil
+}
+
+// addAttendingEvent lists the event in the attending events of the user.
+func addAttendingEvent(txDao *daos.Dao, userId, eventId string) error {
+	attendingEventsRecord, err := FindCompanionRecord(txDao, "attending_events", userId)
+	if err != nil {
+		return err
+	}
+
+	attending := attendingEventsRecord.GetStringSlice("attending_events")
+	if slices.Contains(attending, eventId) {
+		return nil
+	}
+
+	attendingEventsRecord.Set("attending_events", append(attending, eventId))
+	return txDao.SaveRecord(attendingEventsRecord)
+}
+
+func newEventRecord(record *models.Record) eventRecord {
+	return eventRecord{
+		ID:            record.Id,
+		UserId:        record.GetString("user_id"),
+		Title:         record.GetString("name"),
+		Location:      record.GetString("location"),
+		Date:          record.GetString("date"),
+		Description:   record.GetString("description"),
+		Emoji:         record.GetString("emoji"),
+		Visibility:    record.GetString("visibility"),
+		Attendants:    record.GetStringSlice("attendants"),
+		Invited:       record.GetStringSlice("invited"),
+		MaxAttendants: record.GetInt("max_attendants"),
+		Waitlist:      record.GetStringSlice("waitlist"),
+	}
+}
+
+func findEvent(txDao *daos.Dao, eventId string) (*models.Record, error) {
+	event, err := txDao.FindRecordById("events", eventId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, apis.NewNotFoundError("Event not found.", "")
+		}
+		return nil, err
+	}
+
+	return event, nil
+}
+
+func runEventsTransaction(app core.App, fn func(txDao *daos.Dao) error) *apis.ApiError {
+	err := app.Dao().RunInTransaction(fn)
+
+	var apiErr *apis.ApiError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &apiErr):
+		return apiErr
+	default:
+		log.Println("Events transaction failed", err)
+		return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+	}
+}
+
 func GetEvents(app core.App) func(e *core.ServeEvent) error {
 	return func(e *core.ServeEvent) error {
-		e.Router.GET("/api/events", func(c echo.Context) error {
-			session := getSessionToken(c.Request())
-			userId, sessionErr := UserIdFromSession(session)
-			if sessionErr != nil {
-				return sessionErr
-			}
+		apiRoutes(app, e).GET("/events", func(c echo.Context) error {
+			userId := CurrentUser(c).Id
 
-			friendsEvents, err := getFriendsEvents(app, userId)
+			visibleEvents, err := getVisibleEvents(app, userId)
 			if err != nil {
 				return err
 			}
@@ -130,7 +380,7 @@ func GetEvents(app core.App) func(e *core.ServeEvent) error {
 			}
 
 			response := eventResponse{
-				AllEvents:       append(friendsEvents, yourEvents...),
+				AllEvents:       append(visibleEvents, yourEvents...),
 				YourEvents:      yourEvents,
 				AttendingEvents: attendingEvents,
 			}
@@ -141,60 +391,71 @@ func GetEvents(app core.App) func(e *core.ServeEvent) error {
 	}
 }
 
-func getFriendsEvents(app core.App, userId string) ([]eventRecord, error) {
-	friendsRecord, err := app.Dao().FindFirstRecordByData("friends", "user_id", userId)
+// getVisibleEvents returns the other users' events the user may see: events
+// of friends and friends of friends, public events and the events the user is
+// invited to.
+func getVisibleEvents(app core.App, userId string) ([]eventRecord, error) {
+	viewer, err := newEventViewer(app.Dao(), userId)
 	if err != nil {
 		return nil, apis.NewApiError(http.StatusInternalServerError, "Server error", "")
 	}
 
-	var friendList []Friend
-	friends := friendsRecord.Get("friend_list").(types.JsonRaw)
-	err = json.Unmarshal(friends, &friendList)
+	creatorIds := make([]string, 0, len(viewer.friends)+len(viewer.friendsOfFriends))
+	for id := range viewer.friends {
+		creatorIds = append(creatorIds, id)
+	}
+	for id := range viewer.friendsOfFriends {
+		if !viewer.friends[id] {
+			creatorIds = append(creatorIds, id)
+		}
+	}
+
+	creatorsEvents, err := findEventsByCreators(app.Dao(), creatorIds)
 	if err != nil {
 		return nil, apis.NewApiError(http.StatusInternalServerError, "Server error", "")
 	}
 
-	var friendsEvents []eventRecord
-	var quotedFriendsIds []string
-	for _, friend := range friendList {
-		quotedFriendsIds = append(quotedFriendsIds, fmt.Sprintf("'%s'", friend.ID))
+	publicEvents, err := findEventsByVisibility(app.Dao(), EventVisibilityPublic)
+	if err != nil {
+		return nil, apis.NewApiError(http.StatusInternalServerError, "Server error", "")
 	}
-	friendIdsStr := strings.Join(quotedFriendsIds, ",")
-	queryString := fmt.Sprintf("SELECT * FROM events WHERE user_id IN (%s)", friendIdsStr)
 
-	err = app.Dao().DB().NewQuery(queryString).All(&friendsEvents)
+	invitedEvents, err := findEventsInvitedTo(app.Dao(), userId)
 	if err != nil {
 		return nil, apis.NewApiError(http.StatusInternalServerError, "Server error", "")
 	}
 
-	for i := range friendsEvents {
-		if !slices.Contains(friendsEvents[i].Attendants, userId) {
-			friendsEvents[i].CanAttend = true
+	events := []eventRecord{}
+	seen := map[string]bool{}
+	for _, event := range append(append(creatorsEvents, publicEvents...), invitedEvents...) {
+		if seen[event.ID] || event.UserId == userId || !viewer.canSee(event) {
+			continue
 		}
-		friendsEvents[i].AttendantsCount = len(friendsEvents[i].Attendants)
+		seen[event.ID] = true
+
+		event.fillAttendance(userId)
+		event.CanAttend = viewer.canAttend(event)
+		events = append(events, event)
 	}
 
-	return friendsEvents, nil
+	return events, nil
 }
 
 func getYourEvents(app core.App, userId string) ([]eventRecord, error) {
-	var yourEvents []eventRecord
-	queryString := fmt.Sprintf("SELECT * FROM events WHERE user_id IN (%s)", fmt.Sprintf("'%s'", userId))
-
-	err := app.Dao().DB().NewQuery(queryString).All(&yourEvents)
+	yourEvents, err := findEventsByCreators(app.Dao(), []string{userId})
 	if err != nil {
 		return nil, apis.NewApiError(http.StatusInternalServerError, "Server error", "")
 	}
 
 	for i := range yourEvents {
-		yourEvents[i].AttendantsCount = len(yourEvents[i].Attendants)
+		yourEvents[i].fillAttendance(userId)
 	}
 
 	return yourEvents, nil
 }
 
 func getAttendingEvents(app core.App, userId string) ([]eventRecord, error) {
-	attendingEventsRecord, err := app.Dao().FindFirstRecordByData("attending_events", "user_id", userId)
+	attendingEventsRecord, err := FindCompanionRecord(app.Dao(), "attending_events", userId)
 	if err != nil {
 		return nil, apis.NewApiError(http.StatusInternalServerError, "Server error", "")
 	}
@@ -206,21 +467,13 @@ func getAttendingEvents(app core.App, userId string) ([]eventRecord, error) {
 		return nil, apis.NewApiError(http.StatusInternalServerError, "Server error", "")
 	}
 
-	var attendingEvents []eventRecord
-	var quotedEventsIds []string
-	for _, event := range attendingEventsIds {
-		quotedEventsIds = append(quotedEventsIds, fmt.Sprintf("'%s'", event))
-	}
-	eventsIdsStr := strings.Join(quotedEventsIds, ",")
-	queryString := fmt.Sprintf("SELECT * FROM events WHERE id IN (%s)", eventsIdsStr)
-
-	err = app.Dao().DB().NewQuery(queryString).All(&attendingEvents)
+	attendingEvents, err := findEventsByIds(app.Dao(), attendingEventsIds)
 	if err != nil {
 		return nil, apis.NewApiError(http.StatusInternalServerError, "Server error", "")
 	}
 
 	for i := range attendingEvents {
-		attendingEvents[i].AttendantsCount = len(attendingEvents[i].Attendants)
+		attendingEvents[i].fillAttendance(userId)
 	}
 
 	return attendingEvents, nil
@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tokens"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEventAttendance(t *testing.T) {
+	app := newTestApp(t)
+	creator := newTestUser(t, app)
+	guest := newTestUser(t, app)
+	for _, userId := range []string{creator.Id, guest.Id} {
+		assert.NoError(t, provisionUser(app.Dao(), userId))
+	}
+
+	_, err := app.Dao().DB().Insert("events", dbx.Params{
+		"id":         "party",
+		"user_id":    creator.Id,
+		"name":       "party",
+		"visibility": EventVisibilityPublic,
+		"attendants": `["` + creator.Id + `"]`,
+	}).Execute()
+	assert.NoError(t, err)
+
+	currentAttendance := func() ([]string, []string) {
+		event, err := app.Dao().FindRecordById("events", "party")
+		assert.NoError(t, err)
+		attendingEventsRecord, err := FindCompanionRecord(app.Dao(), "attending_events", guest.Id)
+		assert.NoError(t, err)
+		return event.GetStringSlice("attendants"), attendingEventsRecord.GetStringSlice("attending_events")
+	}
+
+	t.Run("should attend only once", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			result, err := attendEvent(app, guest.Id, "party", false)
+			assert.Nil(t, err)
+			assert.Equal(t, &attendance{Status: AttendanceAttending}, result)
+		}
+
+		var rawAttendants string
+		assert.NoError(t, app.Dao().DB().Select("attendants").From("events").Where(dbx.HashExp{"id": "party"}).Row(&rawAttendants))
+		assert.JSONEq(t, `["`+creator.Id+`","`+guest.Id+`"]`, rawAttendants)
+
+		_, attending := currentAttendance()
+		assert.Equal(t, []string{"party"}, attending)
+	})
+
+	t.Run("should leave the event", func(t *testing.T) {
+		assert.Nil(t, leaveEvent(app, &testNotifier{}, guest.Id, "party"))
+		assert.Nil(t, leaveEvent(app, &testNotifier{}, guest.Id, "party"))
+
+		attendants, attending := currentAttendance()
+		assert.Equal(t, []string{creator.Id}, attendants)
+		assert.Empty(t, attending)
+	})
+
+	t.Run("should not let the creator leave", func(t *testing.T) {
+		assertApiErrorCode(t, http.StatusForbidden, leaveEvent(app, &testNotifier{}, creator.Id, "party"))
+	})
+
+	t.Run("should not find unknown events", func(t *testing.T) {
+		_, err := attendEvent(app, guest.Id, "missing", false)
+		assertApiErrorCode(t, http.StatusNotFound, err)
+	})
+}
+
+func TestEventVisibility(t *testing.T) {
+	app := newTestApp(t)
+	creator := newTestUser(t, app)
+	friend := newTestUser(t, app)
+	friendOfFriend := newTestUser(t, app)
+	stranger := newTestUser(t, app)
+	invited := newTestUser(t, app)
+
+	assert.NoError(t, runFriendsTransaction(app, func(txDao *daos.Dao) error {
+		for _, pair := range [][2]string{{creator.Id, friend.Id}, {friend.Id, friendOfFriend.Id}} {
+			if err := sendInvite(txDao, pair[0], pair[1]); err != nil {
+				return err
+			}
+			if err := acceptInvite(txDao, pair[1], pair[0]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	visibleTo := func(visibility string) []string {
+		event := eventRecord{UserId: creator.Id, Visibility: visibility, Invited: []string{invited.Id}}
+
+		var users []string
+		for _, user := range []*models.Record{creator, friend, friendOfFriend, stranger, invited} {
+			viewer, err := newEventViewer(app.Dao(), user.Id)
+			assert.NoError(t, err)
+			if viewer.canSee(event) {
+				users = append(users, user.Id)
+			}
+		}
+		return users
+	}
+
+	t.Run("should follow the visibility of the event", func(t *testing.T) {
+		assert.Equal(t, []string{creator.Id, friend.Id, invited.Id}, visibleTo(EventVisibilityFriends))
+		assert.Equal(t, []string{creator.Id, friend.Id, friendOfFriend.Id, invited.Id}, visibleTo(EventVisibilityFriendsOfFriends))
+		assert.Equal(t, []string{creator.Id, friend.Id, friendOfFriend.Id, stranger.Id, invited.Id}, visibleTo(EventVisibilityPublic))
+		assert.Equal(t, []string{creator.Id, invited.Id}, visibleTo(EventVisibilityInviteOnly))
+	})
+
+	t.Run("should list public and friends of friends events of non-friends", func(t *testing.T) {
+		for id, event := range map[string][2]string{
+			"fof_party":       {friendOfFriend.Id, EventVisibilityFriendsOfFriends},
+			"fof_dinner":      {friendOfFriend.Id, EventVisibilityFriends},
+			"public_concert":  {stranger.Id, EventVisibilityPublic},
+			"stranger_dinner": {stranger.Id, EventVisibilityFriendsOfFriends},
+		} {
+			_, err := app.Dao().DB().Insert("events", dbx.Params{
+				"id":         id,
+				"user_id":    event[0],
+				"name":       id,
+				"visibility": event[1],
+				"attendants": `["` + event[0] + `"]`,
+			}).Execute()
+			assert.NoError(t, err)
+		}
+
+		events, err := getVisibleEvents(app, creator.Id)
+		assert.Nil(t, err)
+
+		var ids []string
+		for _, event := range events {
+			ids = append(ids, event.ID)
+			assert.True(t, event.CanAttend)
+		}
+		assert.ElementsMatch(t, []string{"fof_party", "public_concert"}, ids)
+	})
+
+	t.Run("should hide events from blocked users", func(t *testing.T) {
+		assert.NoError(t, runFriendsTransaction(app, func(txDao *daos.Dao) error {
+			return addBlock(txDao, invited.Id, creator.Id, BlockKindBlock)
+		}))
+
+		assert.NotContains(t, visibleTo(EventVisibilityPublic), invited.Id)
+	})
+}
+
+func TestEventCapacity(t *testing.T) {
+	app := newTestApp(t)
+	creator := newTestUser(t, app)
+	first := newTestUser(t, app)
+	second := newTestUser(t, app)
+	third := newTestUser(t, app)
+	for _, userId := range []string{creator.Id, first.Id, second.Id, third.Id} {
+		assert.NoError(t, provisionUser(app.Dao(), userId))
+	}
+
+	_, err := app.Dao().DB().Insert("events", dbx.Params{
+		"id":             "dinner",
+		"user_id":        creator.Id,
+		"name":           "dinner",
+		"visibility":     EventVisibilityPublic,
+		"attendants":     `["` + creator.Id + `"]`,
+		"max_attendants": 2,
+		"waitlist":       "[]",
+	}).Execute()
+	assert.NoError(t, err)
+
+	_, apiErr := attendEvent(app, first.Id, "dinner", false)
+	assert.Nil(t, apiErr)
+
+	t.Run("should refuse full events", func(t *testing.T) {
+		_, err := attendEvent(app, second.Id, "dinner", false)
+		assertApiErrorCode(t, http.StatusConflict, err)
+	})
+
+	t.Run("should waitlist in order", func(t *testing.T) {
+		for i, user := range []*models.Record{second, third, second} {
+			result, err := attendEvent(app, user.Id, "dinner", true)
+			assert.Nil(t, err)
+			assert.Equal(t, AttendanceWaitlisted, result.Status)
+			assert.Equal(t, []int{1, 2, 1}[i], result.WaitlistPosition)
+		}
+
+		event, err := app.Dao().FindRecordById("events", "dinner")
+		assert.NoError(t, err)
+		record := newEventRecord(event)
+		record.fillAttendance(third.Id)
+		assert.Equal(t, 0, *record.SpotsLeft)
+		assert.Equal(t, 2, record.WaitlistPosition)
+	})
+
+	t.Run("should promote the head of the waitlist when someone leaves", func(t *testing.T) {
+		notifier := &testNotifier{}
+		assert.Nil(t, leaveEvent(app, notifier, first.Id, "dinner"))
+
+		event, err := app.Dao().FindRecordById("events", "dinner")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{creator.Id, second.Id}, event.GetStringSlice("attendants"))
+		assert.Equal(t, []string{third.Id}, event.GetStringSlice("waitlist"))
+
+		attendingEventsRecord, err := FindCompanionRecord(app.Dao(), "attending_events", second.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"dinner"}, attendingEventsRecord.GetStringSlice("attending_events"))
+
+		assert.Equal(t, []string{second.Id}, notifier.notified)
+	})
+
+	t.Run("should only let the creator change the capacity", func(t *testing.T) {
+		assertApiErrorCode(t, http.StatusForbidden, setEventCapacity(app, &testNotifier{}, second.Id, "dinner", 5))
+	})
+
+	t.Run("should promote the waitlist when the capacity is raised", func(t *testing.T) {
+		notifier := &testNotifier{}
+		assert.Nil(t, setEventCapacity(app, notifier, creator.Id, "dinner", 3))
+
+		event, err := app.Dao().FindRecordById("events", "dinner")
+		assert.NoError(t, err)
+		assert.Equal(t, 3, event.GetInt("max_attendants"))
+		assert.Equal(t, []string{creator.Id, second.Id, third.Id}, event.GetStringSlice("attendants"))
+		assert.Empty(t, event.GetStringSlice("waitlist"))
+		assert.Equal(t, []string{third.Id}, notifier.notified)
+	})
+
+	t.Run("should promote the waitlist when an admin raises the capacity", func(t *testing.T) {
+		app := newTestApp(t)
+		creator := newTestUser(t, app)
+		first := newTestUser(t, app)
+		for _, userId := range []string{creator.Id, first.Id} {
+			assert.NoError(t, provisionUser(app.Dao(), userId))
+		}
+
+		notifier := &testNotifier{}
+		app.OnModelAfterUpdate("events").Add(PromoteOnCapacityChange(app, notifier))
+
+		_, err := app.Dao().DB().Insert("events", dbx.Params{
+			"id":             "brunch",
+			"user_id":        creator.Id,
+			"name":           "brunch",
+			"visibility":     EventVisibilityPublic,
+			"attendants":     `["` + creator.Id + `"]`,
+			"max_attendants": 1,
+			"waitlist":       `["` + first.Id + `"]`,
+		}).Execute()
+		assert.NoError(t, err)
+
+		event, err := app.Dao().FindRecordById("events", "brunch")
+		assert.NoError(t, err)
+		event.Set("max_attendants", 2)
+		assert.NoError(t, app.Dao().SaveRecord(event))
+
+		event, err = app.Dao().FindRecordById("events", "brunch")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{creator.Id, first.Id}, event.GetStringSlice("attendants"))
+		assert.Equal(t, []string{first.Id}, notifier.notified)
+	})
+}
+
+func TestCreateEventWithLegacyLimit(t *testing.T) {
+	app := newTestApp(t)
+	BindEventsHooks(app, &testNotifier{})
+	creator := newTestUser(t, app)
+	guest := newTestUser(t, app)
+	late := newTestUser(t, app)
+	for _, userId := range []string{creator.Id, guest.Id, late.Id} {
+		assert.NoError(t, provisionUser(app.Dao(), userId))
+	}
+
+	token, err := tokens.NewRecordAuthToken(app, creator)
+	assert.NoError(t, err)
+	router, err := apis.InitApi(app)
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/collections/events/records", strings.NewReader(
+		`{"name":"picnic","date":"2024-05-01 10:00:00.000Z","location":"park","visibility":"public","limit":2}`,
+	))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", token)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var created struct {
+		ID            string `json:"id"`
+		MaxAttendants int    `json:"max_attendants"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+
+	t.Run("should use the limit as the capacity", func(t *testing.T) {
+		assert.Equal(t, 2, created.MaxAttendants)
+
+		_, err := attendEvent(app, guest.Id, created.ID, false)
+		assert.Nil(t, err)
+
+		_, err = attendEvent(app, late.Id, created.ID, false)
+		assertApiErrorCode(t, http.StatusConflict, err)
+	})
+}
+
+type testNotifier struct {
+	notified []string
+}
+
+func (n *testNotifier) Notify(userId, message string) {
+	n.notified = append(n.notified, userId)
+}
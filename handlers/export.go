@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"io"
+	"log"
+	"net/http"
+	"path"
+)
+
+type exportedFriendship struct {
+	UserId  string         `json:"user_id"`
+	Status  string         `json:"status"`
+	SentBy  string         `json:"sent_by"`
+	ChatId  string         `json:"chat_id"`
+	Created types.DateTime `json:"created"`
+	Updated types.DateTime `json:"updated"`
+}
+
+type exportedChat struct {
+	ID              string         `json:"id"`
+	Type            string         `json:"type"`
+	Description     string         `json:"description"`
+	Participants    []string       `json:"participants"`
+	CommonInterests []string       `json:"common_interests,omitempty"`
+	Archived        bool           `json:"archived"`
+	Created         types.DateTime `json:"created"`
+}
+
+type exportedMatchmaking struct {
+	Queued          bool           `json:"queued"`
+	QueuedInterests []string       `json:"queued_interests,omitempty"`
+	Groups          []exportedChat `json:"groups"`
+}
+
+func GetDataExport(app core.App) func(e *core.ServeEvent) error {
+	return func(e *core.ServeEvent) error {
+		apiRoutes(app, e).GET("/profile/export", func(c echo.Context) error {
+			userRecord := CurrentUser(c)
+
+			// build the whole archive first, a failure halfway can still be
+			// reported with a proper status
+			var archive bytes.Buffer
+			if err := writeDataExport(app, userRecord, &archive); err != nil {
+				log.Println("Failed to export user data", err)
+				return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+			}
+
+			c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="export-`+userRecord.Id+`.zip"`)
+			return c.Blob(http.StatusOK, "application/zip", archive.Bytes())
+		})
+		return nil
+	}
+}
+
+// writeDataExport writes a zip archive with one JSON file per kind of data
+// tied to the user and their uploaded files under attachments/.
+func writeDataExport(app core.App, userRecord *models.Record, w io.Writer) error {
+	archive := zip.NewWriter(w)
+
+	files := []struct {
+		name    string
+		collect func() (any, error)
+	}{
+		{"profile.json", func() (any, error) { return newOwnProfile(userRecord), nil }},
+		{"friendships.json", func() (any, error) { return exportFriendships(app, userRecord.Id) }},
+		{"blocks.json", func() (any, error) {
+			blocked, err := blockTargets(app.Dao(), userRecord.Id, BlockKindBlock)
+			if err != nil {
+				return nil, err
+			}
+			muted, err := blockTargets(app.Dao(), userRecord.Id, BlockKindMute)
+			return blockList{Blocked: blocked, Muted: muted}, err
+		}},
+		{"events_hosted.json", func() (any, error) { return findEventsByCreators(app.Dao(), []string{userRecord.Id}) }},
+		{"events_attended.json", func() (any, error) { return getAttendingEvents(app, userRecord.Id) }},
+		{"chats.json", func() (any, error) { return exportChats(app, userRecord.Id, "") }},
+		{"pending_messages.json", func() (any, error) { return exportPendingMessages(app, userRecord.Id) }},
+		{"matchmaking.json", func() (any, error) { return exportMatchmaking(app, userRecord.Id) }},
+	}
+
+	for _, file := range files {
+		data, err := file.collect()
+		if err != nil {
+			return err
+		}
+
+		writer, err := archive.Create(file.name)
+		if err != nil {
+			return err
+		}
+
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(data); err != nil {
+			return err
+		}
+	}
+
+	if err := exportAttachments(app, userRecord, archive); err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+func exportFriendships(app core.App, userId string) ([]exportedFriendship, error) {
+	friendships, err := app.Dao().FindRecordsByExpr("friendships",
+		dbx.Or(dbx.HashExp{"requester": userId}, dbx.HashExp{"addressee": userId}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	exported := []exportedFriendship{}
+	for _, friendship := range friendships {
+		exported = append(exported, exportedFriendship{
+			UserId:  friendshipCounterpart(friendship, userId),
+			Status:  friendship.GetString("status"),
+			SentBy:  friendship.GetString("requester"),
+			ChatId:  friendship.GetString("chat_id"),
+			Created: friendship.Created,
+			Updated: friendship.Updated,
+		})
+	}
+
+	return exported, nil
+}
+
+// exportChats returns the chats the user participates in, only those of the
+// given type unless it is empty.
+func exportChats(app core.App, userId, chatType string) ([]exportedChat, error) {
+	chats, err := findRecordsContaining(app.Dao(), "chats", "participants", userId)
+	if err != nil {
+		return nil, err
+	}
+
+	exported := []exportedChat{}
+	for _, chat := range chats {
+		if chatType != "" && chat.GetString("type") != chatType {
+			continue
+		}
+
+		exported = append(exported, exportedChat{
+			ID:              chat.Id,
+			Type:            chat.GetString("type"),
+			Description:     chat.GetString("description"),
+			Participants:    chat.GetStringSlice("participants"),
+			CommonInterests: chat.GetStringSlice("common_interests"),
+			Archived:        chat.GetBool("archived"),
+			Created:         chat.Created,
+		})
+	}
+
+	return exported, nil
+}
+
+func exportPendingMessages(app core.App, userId string) ([]json.RawMessage, error) {
+	messagesRecord, err := FindCompanionRecord(app.Dao(), "messages", userId)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending [][]byte
+	if raw, ok := messagesRecord.Get("messages").(types.JsonRaw); ok && len(raw) > 0 {
+		if err := json.Unmarshal(raw, &pending); err != nil {
+			return nil, err
+		}
+	}
+
+	messages := []json.RawMessage{}
+	for _, message := range pending {
+		if json.Valid(message) {
+			messages = append(messages, message)
+		}
+	}
+
+	return messages, nil
+}
+
+func exportMatchmaking(app core.App, userId string) (*exportedMatchmaking, error) {
+	groups, err := exportChats(app, userId, "group")
+	if err != nil {
+		return nil, err
+	}
+
+	matchmaking := &exportedMatchmaking{Groups: groups}
+
+	entry, err := app.Dao().FindFirstRecordByData("chat_finder", "user_id", userId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if entry != nil {
+		matchmaking.Queued = true
+		matchmaking.QueuedInterests = entry.GetStringSlice("interests")
+	}
+
+	return matchmaking, nil
+}
+
+func exportAttachments(app core.App, userRecord *models.Record, archive *zip.Writer) error {
+	avatar := userRecord.GetString("avatar")
+	if avatar == "" {
+		return nil
+	}
+
+	fs, err := app.NewFilesystem()
+	if err != nil {
+		return err
+	}
+	defer fs.Close()
+
+	file, err := fs.GetFile(userRecord.BaseFilesPath() + "/" + avatar)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer, err := archive.Create(path.Join("attachments", avatar))
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(writer, file)
+	return err
+}
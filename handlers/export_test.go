@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+func TestWriteDataExport(t *testing.T) {
+	app := newTestApp(t)
+	user := newTestUser(t, app)
+	friend := newTestUser(t, app)
+	assert.NoError(t, provisionUser(app.Dao(), user.Id))
+
+	avatar, err := filesystem.NewFileFromBytes(testPNG, "avatar.png")
+	assert.NoError(t, err)
+	assert.Nil(t, updateProfile(app, user, profileUpdate{}, avatar))
+
+	assert.NoError(t, runFriendsTransaction(app, func(txDao *daos.Dao) error {
+		if err := sendInvite(txDao, user.Id, friend.Id); err != nil {
+			return err
+		}
+		return acceptInvite(txDao, friend.Id, user.Id)
+	}))
+
+	var buffer bytes.Buffer
+	assert.NoError(t, writeDataExport(app, user, &buffer))
+
+	archive, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	assert.NoError(t, err)
+
+	files := map[string][]byte{}
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		assert.NoError(t, err)
+		files[file.Name], err = io.ReadAll(reader)
+		assert.NoError(t, err)
+		reader.Close()
+	}
+
+	t.Run("should include every kind of data", func(t *testing.T) {
+		for _, name := range []string{
+			"profile.json", "friendships.json", "blocks.json", "events_hosted.json",
+			"events_attended.json", "chats.json", "pending_messages.json", "matchmaking.json",
+		} {
+			assert.Contains(t, files, name)
+		}
+	})
+
+	t.Run("should export the friendships and their chats", func(t *testing.T) {
+		var friendships []exportedFriendship
+		assert.NoError(t, json.Unmarshal(files["friendships.json"], &friendships))
+		if assert.Len(t, friendships, 1) {
+			assert.Equal(t, friend.Id, friendships[0].UserId)
+			assert.Equal(t, FriendshipAccepted, friendships[0].Status)
+		}
+
+		var chats []exportedChat
+		assert.NoError(t, json.Unmarshal(files["chats.json"], &chats))
+		assert.Len(t, chats, 1)
+	})
+
+	t.Run("should attach the avatar", func(t *testing.T) {
+		assert.Equal(t, testPNG, files["attachments/"+user.GetString("avatar")])
+	})
+}
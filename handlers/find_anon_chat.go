@@ -4,7 +4,6 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"github.com/bogdancanciu/frekathon-backend/strategy"
 	"github.com/labstack/echo/v5"
 	"github.com/pocketbase/pocketbase/apis"
@@ -14,7 +13,6 @@ import (
 	"golang.org/x/exp/rand"
 	"log"
 	"net/http"
-	"strings"
 	"time"
 )
 
@@ -34,18 +32,8 @@ func BindChatFinderHooks(app core.App) {
 
 func FindChat(app core.App) func(e *core.ServeEvent) error {
 	return func(e *core.ServeEvent) error {
-		e.Router.POST("/api/find-chat", func(c echo.Context) error {
-			session := getSessionToken(c.Request())
-			userId, sessionErr := UserIdFromSession(session)
-			if sessionErr != nil {
-				return sessionErr
-			}
-
-			userRecord, err := app.Dao().FindRecordById("users", userId)
-			if err != nil {
-				return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
-			}
-
+		apiRoutes(app, e).POST("/find-chat", func(c echo.Context) error {
+			userRecord := CurrentUser(c)
 			interests := userRecord.Get("interests")
 
 			chatFinderCollection, err := app.Dao().FindCollectionByNameOrId("chat_finder")
@@ -55,7 +43,7 @@ func FindChat(app core.App) func(e *core.ServeEvent) error {
 
 			chatFinderRecord := models.NewRecord(chatFinderCollection)
 
-			chatFinderRecord.Set("user_id", userId)
+			chatFinderRecord.Set("user_id", userRecord.Id)
 			chatFinderRecord.Set("interests", interests)
 
 			if err := app.Dao().SaveRecord(chatFinderRecord); err != nil {
@@ -70,12 +58,8 @@ func FindChat(app core.App) func(e *core.ServeEvent) error {
 
 func CanFindChat(app core.App) func(e *core.ServeEvent) error {
 	return func(e *core.ServeEvent) error {
-		e.Router.GET("/api/find-chat", func(c echo.Context) error {
-			session := getSessionToken(c.Request())
-			userId, sessionErr := UserIdFromSession(session)
-			if sessionErr != nil {
-				return sessionErr
-			}
+		apiRoutes(app, e).GET("/find-chat", func(c echo.Context) error {
+			userId := CurrentUser(c).Id
 
 			_, err := app.Dao().FindFirstRecordByData("chat_finder", "user_id", userId)
 			if err != nil {
@@ -102,13 +86,29 @@ func matchExistingUsers(app core.App) error {
 
 	var chatUsers []*strategy.User
 	for _, record := range chatFinderRecords {
+		blocked, err := blockedIds(app.Dao(), record.ID)
+		if err != nil {
+			// matching without the blocks could group users who blocked each other
+			log.Println("Error fetching blocked users", err)
+			return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+		}
+
 		chatUsers = append(chatUsers, &strategy.User{
 			ID:        record.ID,
 			Interests: record.Interests,
+			Blocked:   blocked,
 		})
 	}
 
 	matchingStrategy := strategy.NewMatchingStrategy(chatUsers)
+
+	catalog, err := loadInterestCatalog(app.Dao())
+	if err != nil {
+		log.Println("Failed to load the interests catalog, matching equal interests only", err)
+	} else {
+		matchingStrategy.WithTaxonomy(catalog, interestSimilarityThreshold)
+	}
+
 	matches, commonInterests := matchingStrategy.FindMatchingGroups()
 	if len(matches) > 0 {
 		var groupParticipatingUsers []string
@@ -132,7 +132,7 @@ func matchExistingUsers(app core.App) error {
 			return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
 		}
 
-		err = deleteUsersFromChatFinder(app, groupParticipatingUsers)
+		err = deleteChatFinderEntries(app.Dao(), groupParticipatingUsers)
 		if err != nil {
 			log.Println("Failed to delete users from chat finder", err)
 			return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
@@ -155,25 +155,9 @@ func randomGroupDescription() string {
 	return descriptionsPool[rand.Int()%len(descriptionsPool)]
 }
 
-func deleteUsersFromChatFinder(app core.App, users []string) error {
-	var quotedUserIds []string
-	for _, id := range users {
-		quotedUserIds = append(quotedUserIds, fmt.Sprintf("'%s'", id))
-	}
-	userIdsStr := strings.Join(quotedUserIds, ",")
-	query := fmt.Sprintf("DELETE FROM chat_finder WHERE user_id IN (%s)", userIdsStr)
-
-	_, err := app.Dao().DB().NewQuery(query).Execute()
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
 func updateUsersActiveChats(app core.App, users []string, chatRecord *models.Record) error {
 	for _, user := range users {
-		messagesRecord, err := app.Dao().FindFirstRecordByData("messages", "user_id", user)
+		messagesRecord, err := FindCompanionRecord(app.Dao(), "messages", user)
 		if err != nil {
 			return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
 		}
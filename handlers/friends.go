@@ -1,42 +1,58 @@
 package handlers
 
 import (
-	"encoding/json"
+	"database/sql"
+	"errors"
 	"github.com/labstack/echo/v5"
 	"github.com/pocketbase/pocketbase/apis"
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
 	"github.com/pocketbase/pocketbase/models"
-	"golang.org/x/exp/slices"
 	"log"
 	"net/http"
 )
 
-type Friend struct {
-	ID     string `json:"id"`
-	Name   string `json:"name"`
-	ChatId string `json:"chat_id"`
-}
-
-func BindFriendsHooks(app core.App) {
+func BindFriendsHooks(app core.App, presence Presence) {
+	app.OnBeforeServe().Add(GetFriends(app, presence))
+	app.OnBeforeServe().Add(GetFriendSuggestions(app))
 	app.OnBeforeServe().Add(AddFriend(app))
 	app.OnBeforeServe().Add(AcceptFriend(app))
+	app.OnBeforeServe().Add(DeclineFriend(app))
+	app.OnBeforeServe().Add(CancelInvite(app))
+	app.OnBeforeServe().Add(RemoveFriend(app))
 }
 
 func AddFriend(app core.App) func(e *core.ServeEvent) error {
 	return func(e *core.ServeEvent) error {
-		e.Router.POST("/api/friends/:friend_id", func(c echo.Context) error {
-			session := getSessionToken(c.Request())
-			userId, sessionErr := UserIdFromSession(session)
-			if sessionErr != nil {
-				return sessionErr
+		apiRoutes(app, e).POST("/friends/:friend_id", func(c echo.Context) error {
+			userId := CurrentUser(c).Id
+			friendId := c.PathParam("friend_id")
+			if friendId == userId {
+				return apis.NewBadRequestError("You can't send an invitation to yourself.", "")
 			}
 
-			err := registerCurrentUserSentInvite(app, c, userId)
+			err := runFriendsTransaction(app, func(txDao *daos.Dao) error {
+				return sendInvite(txDao, userId, friendId)
+			})
 			if err != nil {
 				return err
 			}
 
-			err = updateFriendPendingList(app, c, userId)
+			return c.NoContent(http.StatusOK)
+		})
+		return nil
+	}
+}
+
+func AcceptFriend(app core.App) func(e *core.ServeEvent) error {
+	return func(e *core.ServeEvent) error {
+		apiRoutes(app, e).PUT("/friends/:friend_id", func(c echo.Context) error {
+			userId := CurrentUser(c).Id
+			friendId := c.PathParam("friend_id")
+
+			err := runFriendsTransaction(app, func(txDao *daos.Dao) error {
+				return acceptInvite(txDao, userId, friendId)
+			})
 			if err != nil {
 				return err
 			}
@@ -47,33 +63,53 @@ func AddFriend(app core.App) func(e *core.ServeEvent) error {
 	}
 }
 
-func AcceptFriend(app core.App) func(e *core.ServeEvent) error {
+func DeclineFriend(app core.App) func(e *core.ServeEvent) error {
 	return func(e *core.ServeEvent) error {
-		e.Router.PUT("/api/friends/:friend_id", func(c echo.Context) error {
-			session := getSessionToken(c.Request())
-			userId, sessionErr := UserIdFromSession(session)
-			if sessionErr != nil {
-				return sessionErr
-			}
-
+		apiRoutes(app, e).PUT("/friends/:friend_id/decline", func(c echo.Context) error {
+			userId := CurrentUser(c).Id
 			friendId := c.PathParam("friend_id")
-			chatParticipants := []string{userId, friendId}
-			chatId, err := createChat(app, chatParticipants, "dm", "")
-			if err != nil {
-				log.Println("Error while creating friend chat", err)
-			}
 
-			err = updateCurrentUserPendingList(app, c, userId)
+			err := runFriendsTransaction(app, func(txDao *daos.Dao) error {
+				return declineInvite(txDao, userId, friendId)
+			})
 			if err != nil {
 				return err
 			}
 
-			err = updateFriendSentInvites(app, c, userId)
+			return c.NoContent(http.StatusOK)
+		})
+		return nil
+	}
+}
+
+func CancelInvite(app core.App) func(e *core.ServeEvent) error {
+	return func(e *core.ServeEvent) error {
+		apiRoutes(app, e).DELETE("/friends/:friend_id/invite", func(c echo.Context) error {
+			userId := CurrentUser(c).Id
+			friendId := c.PathParam("friend_id")
+
+			err := runFriendsTransaction(app, func(txDao *daos.Dao) error {
+				return cancelInvite(txDao, userId, friendId)
+			})
 			if err != nil {
 				return err
 			}
 
-			err = addFriendToFriendList(app, c, userId, chatId)
+			return c.NoContent(http.StatusOK)
+		})
+		return nil
+	}
+}
+
+func RemoveFriend(app core.App) func(e *core.ServeEvent) error {
+	return func(e *core.ServeEvent) error {
+		apiRoutes(app, e).DELETE("/friends/:friend_id", func(c echo.Context) error {
+			userId := CurrentUser(c).Id
+			friendId := c.PathParam("friend_id")
+
+			err := runFriendsTransaction(app, func(txDao *daos.Dao) error {
+				return removeFriend(txDao, userId, friendId)
+			})
 			if err != nil {
 				return err
 			}
@@ -84,213 +120,165 @@ func AcceptFriend(app core.App) func(e *core.ServeEvent) error {
 	}
 }
 
-func registerCurrentUserSentInvite(app core.App, c echo.Context, userId string) *apis.ApiError {
-	friendId := c.PathParam("friend_id")
+// runFriendsTransaction runs fn in a single transaction. SQLite serializes
+// write transactions, so what fn reads can't change before it commits.
+func runFriendsTransaction(app core.App, fn func(txDao *daos.Dao) error) error {
+	err := app.Dao().RunInTransaction(fn)
 
-	record, err := app.Dao().FindFirstRecordByData("friends", "user_id", userId)
-	if err != nil {
+	var apiErr *apis.ApiError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &apiErr):
+		return apiErr
+	default:
+		log.Println("Friends transaction failed", err)
 		return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
 	}
+}
 
-	sentInvites, dbErr := getSentInvites(record)
-	if dbErr != nil {
-		return dbErr
-	}
-
-	for _, i := range sentInvites {
-		if i.ID == friendId {
-			return apis.NewApiError(http.StatusConflict, "Invitation already sent.", "")
+func sendInvite(txDao *daos.Dao, userId, friendId string) error {
+	if _, err := txDao.FindRecordById("users", friendId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return apis.NewNotFoundError("User not found.", "")
 		}
+		return err
 	}
 
-	friendRecord, err := app.Dao().FindFirstRecordByData("users", "id", friendId)
+	blocked, err := isBlocked(txDao, userId, friendId)
 	if err != nil {
-		return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+		return err
 	}
-
-	sentInvites = append(sentInvites, Friend{ID: friendRecord.GetId(), Name: friendRecord.Get("name").(string)})
-	record.Set("sent_invites", sentInvites)
-	if err := app.Dao().SaveRecord(record); err != nil {
-		return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+	if blocked {
+		return apis.NewForbiddenError("You can't invite this user.", "")
 	}
 
-	return nil
-}
+	friendship, err := findFriendship(txDao, userId, friendId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
 
-func updateCurrentUserPendingList(app core.App, c echo.Context, userId string) *apis.ApiError {
-	friendId := c.PathParam("friend_id")
+	if friendship == nil {
+		friendship, err = newFriendship(txDao, userId, friendId)
+		if err != nil {
+			return err
+		}
 
-	record, err := app.Dao().FindFirstRecordByData("friends", "user_id", userId)
-	if err != nil {
-		return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+		return txDao.SaveRecord(friendship)
 	}
 
-	pendingList, dbErr := getPendingList(record)
-	if dbErr != nil {
-		return dbErr
+	switch friendship.GetString("status") {
+	case FriendshipAccepted:
+		return apis.NewApiError(http.StatusConflict, "Already friends.", "")
+	case FriendshipPending:
+		if friendship.GetString("requester") == userId {
+			return apis.NewApiError(http.StatusConflict, "Invitation already sent.", "")
+		}
+		return apis.NewApiError(http.StatusConflict, "This user already invited you.", "")
 	}
 
-	pendingList = slices.DeleteFunc(pendingList, func(f Friend) bool {
-		return f.ID == friendId
-	})
-	record.Set("pending_list", pendingList)
-	if err := app.Dao().SaveRecord(record); err != nil {
-		return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
-	}
+	// a declined invitation can be sent again, by either side
+	friendship.Set("requester", userId)
+	friendship.Set("addressee", friendId)
+	friendship.Set("status", FriendshipPending)
 
-	return nil
+	return txDao.SaveRecord(friendship)
 }
 
-func updateFriendPendingList(app core.App, c echo.Context, userId string) *apis.ApiError {
-	friendId := c.PathParam("friend_id")
-
-	friendRecord, err := app.Dao().FindFirstRecordByData("friends", "user_id", friendId)
-	if err != nil {
-		return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+func acceptInvite(txDao *daos.Dao, userId, friendId string) error {
+	friendship, err := findFriendship(txDao, userId, friendId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
 	}
 
-	pendingList, dbErr := getPendingList(friendRecord)
-	if dbErr != nil {
-		return dbErr
+	if friendship == nil ||
+		friendship.GetString("status") != FriendshipPending ||
+		friendship.GetString("addressee") != userId {
+		return apis.NewNotFoundError("No pending invitation from this user.", "")
 	}
 
-	for _, p := range pendingList {
-		if p.ID == userId {
-			return apis.NewApiError(http.StatusConflict, "Invitation already pending.", "")
-		}
-	}
-
-	currentUserRecord, err := app.Dao().FindFirstRecordByData("users", "id", userId)
+	chatId, err := createChat(txDao, []string{userId, friendId}, "dm", "")
 	if err != nil {
-		return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+		return err
 	}
 
-	pendingList = append(pendingList, Friend{ID: currentUserRecord.GetId(), Name: currentUserRecord.Get("name").(string)})
-	friendRecord.Set("pending_list", pendingList)
-	if err := app.Dao().SaveRecord(friendRecord); err != nil {
-		return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
-	}
+	friendship.Set("status", FriendshipAccepted)
+	friendship.Set("chat_id", chatId)
 
-	return nil
+	return txDao.SaveRecord(friendship)
 }
 
-func updateFriendSentInvites(app core.App, c echo.Context, userId string) *apis.ApiError {
-	friendId := c.PathParam("friend_id")
-
-	record, err := app.Dao().FindFirstRecordByData("friends", "user_id", friendId)
+func declineInvite(txDao *daos.Dao, userId, friendId string) error {
+	friendship, err := findPendingInvite(txDao, friendId, userId)
 	if err != nil {
-		return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
-	}
-
-	sentInvites, dbErr := getSentInvites(record)
-	if dbErr != nil {
-		return dbErr
+		return err
 	}
 
-	sentInvites = slices.DeleteFunc(sentInvites, func(f Friend) bool {
-		return f.ID == userId
-	})
-	record.Set("sent_invites", sentInvites)
-	if err := app.Dao().SaveRecord(record); err != nil {
-		return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
-	}
+	friendship.Set("status", FriendshipDeclined)
 
-	return nil
+	return txDao.SaveRecord(friendship)
 }
 
-func addFriendToFriendList(app core.App, c echo.Context, userId, chatId string) *apis.ApiError {
-	friendId := c.PathParam("friend_id")
-
-	currentUserRecord, err := app.Dao().FindFirstRecordByData("friends", "user_id", userId)
-	if err != nil {
-		return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
-	}
-
-	friendUserRecord, err := app.Dao().FindFirstRecordByData("friends", "user_id", friendId)
+func cancelInvite(txDao *daos.Dao, userId, friendId string) error {
+	friendship, err := findPendingInvite(txDao, userId, friendId)
 	if err != nil {
-		return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+		return err
 	}
 
-	currentUserFriendList, dbErr := getFriendList(currentUserRecord)
-	if dbErr != nil {
-		return dbErr
-	}
+	return txDao.DeleteRecord(friendship)
+}
 
-	peerFriendList, dbErr := getFriendList(friendUserRecord)
-	if dbErr != nil {
-		return dbErr
+func removeFriend(txDao *daos.Dao, userId, friendId string) error {
+	friendship, err := findFriendship(txDao, userId, friendId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
 	}
 
-	currentUser, err := app.Dao().FindFirstRecordByData("users", "id", userId)
-	if err != nil {
-		return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+	if friendship == nil || friendship.GetString("status") != FriendshipAccepted {
+		return apis.NewNotFoundError("Not friends with this user.", "")
 	}
 
-	friendRecord, err := app.Dao().FindFirstRecordByData("users", "id", friendId)
-	if err != nil {
-		return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
-	}
-
-	currentUserFriendList = append(currentUserFriendList, Friend{ID: friendId, Name: friendRecord.Get("name").(string), ChatId: chatId})
-	currentUserRecord.Set("friend_list", currentUserFriendList)
-
-	peerFriendList = append(peerFriendList, Friend{ID: userId, Name: currentUser.Get("name").(string), ChatId: chatId})
-	friendUserRecord.Set("friend_list", peerFriendList)
-
-	if err := app.Dao().SaveRecord(currentUserRecord); err != nil {
-		return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
-	}
-	if err := app.Dao().SaveRecord(friendUserRecord); err != nil {
-		return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
-	}
-
-	return nil
+	return endFriendship(txDao, friendship)
 }
 
-func getFriendList(record *models.Record) ([]Friend, *apis.ApiError) {
-	friendList := record.Get("friend_list")
-	jsonBytes, err := json.Marshal(friendList)
-	if err != nil {
-		return nil, apis.NewApiError(http.StatusInternalServerError, "Server error", "")
-	}
-
-	var friends []Friend
-	err = json.Unmarshal(jsonBytes, &friends)
-	if err != nil {
-		return nil, apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+// endFriendship deletes the friendship and archives the chat the two users
+// shared, if any.
+func endFriendship(txDao *daos.Dao, friendship *models.Record) error {
+	if chatId := friendship.GetString("chat_id"); chatId != "" {
+		if err := archiveChat(txDao, chatId); err != nil {
+			return err
+		}
 	}
 
-	return friends, nil
+	return txDao.DeleteRecord(friendship)
 }
 
-func getPendingList(record *models.Record) ([]Friend, *apis.ApiError) {
-	pendingList := record.Get("pending_list")
-	jsonBytes, err := json.Marshal(pendingList)
-	if err != nil {
-		return nil, apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+// findPendingInvite returns the pending friendship sent by requester to addressee.
+func findPendingInvite(txDao *daos.Dao, requester, addressee string) (*models.Record, error) {
+	friendship, err := findFriendship(txDao, requester, addressee)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
 	}
 
-	var pending []Friend
-	err = json.Unmarshal(jsonBytes, &pending)
-	if err != nil {
-		return nil, apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+	if friendship == nil ||
+		friendship.GetString("status") != FriendshipPending ||
+		friendship.GetString("requester") != requester {
+		return nil, apis.NewNotFoundError("No pending invitation between these users.", "")
 	}
 
-	return pending, nil
+	return friendship, nil
 }
 
-func getSentInvites(record *models.Record) ([]Friend, *apis.ApiError) {
-	sentInvites := record.Get("sent_invites")
-	jsonBytes, err := json.Marshal(sentInvites)
+func archiveChat(txDao *daos.Dao, chatId string) error {
+	chat, err := txDao.FindRecordById("chats", chatId)
 	if err != nil {
-		return nil, apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
 	}
 
-	var invited []Friend
-	err = json.Unmarshal(jsonBytes, &invited)
-	if err != nil {
-		return nil, apis.NewApiError(http.StatusInternalServerError, "Server error", "")
-	}
+	chat.Set("archived", true)
 
-	return invited, nil
+	return txDao.SaveRecord(chat)
 }
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"net/http"
+	"strconv"
+)
+
+const (
+	FriendsListAccepted = "accepted"
+	FriendsListPending  = "pending"
+	FriendsListSent     = "sent"
+
+	defaultFriendsPageSize = 20
+	maxFriendsPageSize     = 100
+)
+
+// Presence reports whether a user is connected to the chat hub.
+type Presence interface {
+	IsOnline(userId string) bool
+}
+
+type friendsPage struct {
+	Items      []friendItem `json:"items"`
+	NextCursor string       `json:"next_cursor"`
+}
+
+type friendItem struct {
+	FriendshipId string `db:"friendship_id" json:"-"`
+	userSummary
+	ChatId string `db:"chat_id" json:"chat_id"`
+	Online bool   `json:"online"`
+}
+
+func GetFriends(app core.App, presence Presence) func(e *core.ServeEvent) error {
+	return func(e *core.ServeEvent) error {
+		apiRoutes(app, e).GET("/friends", func(c echo.Context) error {
+			userId := CurrentUser(c).Id
+
+			status := c.QueryParam("status")
+			if status == "" {
+				status = FriendsListAccepted
+			}
+			if status != FriendsListAccepted && status != FriendsListPending && status != FriendsListSent {
+				return apis.NewBadRequestError("Status must be one of accepted, pending or sent.", "")
+			}
+
+			limit := defaultFriendsPageSize
+			if rawLimit := c.QueryParam("limit"); rawLimit != "" {
+				parsed, err := strconv.Atoi(rawLimit)
+				if err != nil || parsed < 1 {
+					return apis.NewBadRequestError("Limit must be a positive number.", "")
+				}
+				limit = min(parsed, maxFriendsPageSize)
+			}
+
+			page, err := listFriends(app.Dao(), userId, status, c.QueryParam("cursor"), limit)
+			if err != nil {
+				return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+			}
+
+			for i := range page.Items {
+				page.Items[i].Online = presence.IsOnline(page.Items[i].ID)
+			}
+
+			return c.JSON(http.StatusOK, page)
+		})
+		return nil
+	}
+}
+
+// listFriends returns a page of the user's friendships in the given list,
+// ordered by friendship ID. The cursor is the friendship ID of the last item
+// of the previous page.
+func listFriends(dao *daos.Dao, userId, status, cursor string, limit int) (*friendsPage, error) {
+	var filter dbx.Expression
+	switch status {
+	case FriendsListPending:
+		filter = dbx.HashExp{"f.status": FriendshipPending, "f.addressee": userId}
+	case FriendsListSent:
+		filter = dbx.HashExp{"f.status": FriendshipPending, "f.requester": userId}
+	default:
+		filter = dbx.And(
+			dbx.HashExp{"f.status": FriendshipAccepted},
+			dbx.Or(dbx.HashExp{"f.requester": userId}, dbx.HashExp{"f.addressee": userId}),
+		)
+	}
+
+	query := dao.DB().
+		Select("f.id AS friendship_id", "u.id", "u.name", "u.tag", "u.avatar", "u.privacy", "f.chat_id").
+		From("friendships f").
+		InnerJoin("users u", dbx.NewExp(
+			"u.id = (CASE WHEN f.requester = {:userId} THEN f.addressee ELSE f.requester END)",
+			dbx.Params{"userId": userId},
+		)).
+		Where(filter).
+		OrderBy("f.id ASC").
+		// one extra row tells whether there is a next page
+		Limit(int64(limit + 1))
+	if cursor != "" {
+		query.AndWhere(dbx.NewExp("f.id > {:cursor}", dbx.Params{"cursor": cursor}))
+	}
+
+	items := []friendItem{}
+	if err := query.All(&items); err != nil {
+		return nil, err
+	}
+
+	page := &friendsPage{Items: items}
+	if len(items) > limit {
+		page.Items = items[:limit]
+		page.NextCursor = page.Items[limit-1].FriendshipId
+	}
+
+	relationship := RelationshipPending
+	if status == FriendsListAccepted {
+		relationship = RelationshipFriend
+	}
+	for i := range page.Items {
+		page.Items[i].hidePrivate(relationship)
+	}
+
+	return page, nil
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestListFriends(t *testing.T) {
+	app := newTestApp(t)
+	user := newTestUser(t, app)
+	friends := []string{newTestUser(t, app).Id, newTestUser(t, app).Id, newTestUser(t, app).Id}
+	requester := newTestUser(t, app)
+	addressee := newTestUser(t, app)
+
+	for _, friendId := range friends {
+		assert.NoError(t, runFriendsTransaction(app, func(txDao *daos.Dao) error {
+			if err := sendInvite(txDao, user.Id, friendId); err != nil {
+				return err
+			}
+			return acceptInvite(txDao, friendId, user.Id)
+		}))
+	}
+	assert.NoError(t, runFriendsTransaction(app, func(txDao *daos.Dao) error {
+		return sendInvite(txDao, requester.Id, user.Id)
+	}))
+	assert.NoError(t, runFriendsTransaction(app, func(txDao *daos.Dao) error {
+		return sendInvite(txDao, user.Id, addressee.Id)
+	}))
+
+	t.Run("should page through accepted friends", func(t *testing.T) {
+		first, err := listFriends(app.Dao(), user.Id, FriendsListAccepted, "", 2)
+		assert.NoError(t, err)
+		assert.Len(t, first.Items, 2)
+		assert.NotEmpty(t, first.NextCursor)
+
+		second, err := listFriends(app.Dao(), user.Id, FriendsListAccepted, first.NextCursor, 2)
+		assert.NoError(t, err)
+		assert.Len(t, second.Items, 1)
+		assert.Empty(t, second.NextCursor)
+
+		var ids []string
+		for _, item := range append(first.Items, second.Items...) {
+			assert.Equal(t, "Test User", item.Name)
+			assert.NotEmpty(t, item.ChatId)
+			ids = append(ids, item.ID)
+		}
+		assert.ElementsMatch(t, friends, ids)
+	})
+
+	t.Run("should list incoming and sent invitations separately", func(t *testing.T) {
+		pending, err := listFriends(app.Dao(), user.Id, FriendsListPending, "", 10)
+		assert.NoError(t, err)
+		if assert.Len(t, pending.Items, 1) {
+			assert.Equal(t, requester.Id, pending.Items[0].ID)
+		}
+
+		sent, err := listFriends(app.Dao(), user.Id, FriendsListSent, "", 10)
+		assert.NoError(t, err)
+		if assert.Len(t, sent.Items, 1) {
+			assert.Equal(t, addressee.Id, sent.Items[0].ID)
+		}
+	})
+
+	t.Run("should hide fields the user keeps from non-friends", func(t *testing.T) {
+		addressee.Set("privacy", map[string]string{"name": VisibilityFriends})
+		assert.NoError(t, app.Dao().SaveRecord(addressee))
+
+		sent, err := listFriends(app.Dao(), user.Id, FriendsListSent, "", 10)
+		assert.NoError(t, err)
+		if assert.Len(t, sent.Items, 1) {
+			assert.Empty(t, sent.Items[0].Name)
+		}
+
+		friend, err := app.Dao().FindRecordById("users", friends[0])
+		assert.NoError(t, err)
+		friend.Set("privacy", map[string]string{"name": VisibilityFriends})
+		assert.NoError(t, app.Dao().SaveRecord(friend))
+
+		accepted, err := listFriends(app.Dao(), user.Id, FriendsListAccepted, "", 10)
+		assert.NoError(t, err)
+		for _, item := range accepted.Items {
+			assert.Equal(t, "Test User", item.Name)
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"database/sql"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestFriendInvites(t *testing.T) {
+	app := newTestApp(t)
+	user := newTestUser(t, app)
+	friend := newTestUser(t, app)
+
+	invite := func(userId, friendId string) error {
+		return runFriendsTransaction(app, func(txDao *daos.Dao) error {
+			return sendInvite(txDao, userId, friendId)
+		})
+	}
+	accept := func(userId, friendId string) error {
+		return runFriendsTransaction(app, func(txDao *daos.Dao) error {
+			return acceptInvite(txDao, userId, friendId)
+		})
+	}
+
+	t.Run("should refuse inviting an unknown user", func(t *testing.T) {
+		assertApiErrorCode(t, http.StatusNotFound, invite(user.Id, "unknown_user"))
+	})
+
+	t.Run("should refuse accepting without a pending invitation", func(t *testing.T) {
+		assertApiErrorCode(t, http.StatusNotFound, accept(friend.Id, user.Id))
+	})
+
+	t.Run("should register a pending friendship", func(t *testing.T) {
+		assert.NoError(t, invite(user.Id, friend.Id))
+		assertFriendshipStatus(t, app, user.Id, friend.Id, FriendshipPending)
+	})
+
+	t.Run("should refuse sending the same invitation twice", func(t *testing.T) {
+		assertApiErrorCode(t, http.StatusConflict, invite(user.Id, friend.Id))
+		assertApiErrorCode(t, http.StatusConflict, invite(friend.Id, user.Id))
+	})
+
+	t.Run("should refuse accepting an invitation you sent", func(t *testing.T) {
+		assertApiErrorCode(t, http.StatusNotFound, accept(user.Id, friend.Id))
+	})
+
+	t.Run("should befriend both users with a shared chat", func(t *testing.T) {
+		assert.NoError(t, accept(friend.Id, user.Id))
+		friendship := assertFriendshipStatus(t, app, user.Id, friend.Id, FriendshipAccepted)
+
+		_, err := app.Dao().FindRecordById("chats", friendship.GetString("chat_id"))
+		assert.NoError(t, err)
+
+		ids, err := friendIds(app.Dao(), friend.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{user.Id}, ids)
+	})
+}
+
+func TestEndFriendships(t *testing.T) {
+	app := newTestApp(t)
+	user := newTestUser(t, app)
+	friend := newTestUser(t, app)
+
+	run := func(fn func(txDao *daos.Dao) error) error {
+		return runFriendsTransaction(app, fn)
+	}
+	invite := func(txDao *daos.Dao) error { return sendInvite(txDao, user.Id, friend.Id) }
+
+	t.Run("should decline an incoming invitation", func(t *testing.T) {
+		assert.NoError(t, run(invite))
+		assertApiErrorCode(t, http.StatusNotFound, run(func(txDao *daos.Dao) error {
+			return declineInvite(txDao, user.Id, friend.Id)
+		}))
+
+		assert.NoError(t, run(func(txDao *daos.Dao) error {
+			return declineInvite(txDao, friend.Id, user.Id)
+		}))
+		assertFriendshipStatus(t, app, user.Id, friend.Id, FriendshipDeclined)
+	})
+
+	t.Run("should withdraw a sent invitation", func(t *testing.T) {
+		assert.NoError(t, run(invite))
+		assert.NoError(t, run(func(txDao *daos.Dao) error {
+			return cancelInvite(txDao, user.Id, friend.Id)
+		}))
+
+		_, err := findFriendship(app.Dao(), user.Id, friend.Id)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("should unfriend and archive the shared chat", func(t *testing.T) {
+		assert.NoError(t, run(invite))
+		assert.NoError(t, run(func(txDao *daos.Dao) error {
+			return acceptInvite(txDao, friend.Id, user.Id)
+		}))
+		friendship, err := findFriendship(app.Dao(), user.Id, friend.Id)
+		assert.NoError(t, err)
+
+		assert.NoError(t, run(func(txDao *daos.Dao) error {
+			return removeFriend(txDao, friend.Id, user.Id)
+		}))
+
+		_, err = findFriendship(app.Dao(), user.Id, friend.Id)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		chat, err := app.Dao().FindRecordById("chats", friendship.GetString("chat_id"))
+		assert.NoError(t, err)
+		assert.True(t, chat.GetBool("archived"))
+	})
+}
+
+func assertApiErrorCode(t *testing.T, code int, err error) {
+	apiErr, ok := err.(*apis.ApiError)
+	if assert.True(t, ok, "expected *apis.ApiError, got %v", err) {
+		assert.Equal(t, code, apiErr.Code)
+	}
+}
+
+func assertFriendshipStatus(t *testing.T, app core.App, userId, otherId, status string) *models.Record {
+	friendship, err := findFriendship(app.Dao(), userId, otherId)
+	if assert.NoError(t, err) {
+		assert.Equal(t, status, friendship.GetString("status"))
+	}
+
+	return friendship
+}
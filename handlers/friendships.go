@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"sort"
+	"strings"
+)
+
+const (
+	FriendshipPending  = "pending"
+	FriendshipAccepted = "accepted"
+	FriendshipDeclined = "declined"
+)
+
+// friendshipPair returns the key identifying the pair regardless of who sent
+// the invitation.
+func friendshipPair(userId, otherId string) string {
+	ids := []string{userId, otherId}
+	sort.Strings(ids)
+
+	return strings.Join(ids, ":")
+}
+
+func findFriendship(dao *daos.Dao, userId, otherId string) (*models.Record, error) {
+	return dao.FindFirstRecordByData("friendships", "pair", friendshipPair(userId, otherId))
+}
+
+func newFriendship(dao *daos.Dao, requester, addressee string) (*models.Record, error) {
+	collection, err := dao.FindCollectionByNameOrId("friendships")
+	if err != nil {
+		return nil, err
+	}
+
+	record := models.NewRecord(collection)
+	record.Set("requester", requester)
+	record.Set("addressee", addressee)
+	record.Set("pair", friendshipPair(requester, addressee))
+	record.Set("status", FriendshipPending)
+
+	return record, nil
+}
+
+// friendshipCounterpart returns the ID of the other user in the friendship.
+func friendshipCounterpart(friendship *models.Record, userId string) string {
+	if requester := friendship.GetString("requester"); requester != userId {
+		return requester
+	}
+
+	return friendship.GetString("addressee")
+}
+
+// friendIds returns the IDs of every user with an accepted friendship with
+// the user.
+func friendIds(dao *daos.Dao, userId string) ([]string, error) {
+	friendships, err := dao.FindRecordsByExpr("friendships",
+		dbx.HashExp{"status": FriendshipAccepted},
+		dbx.Or(
+			dbx.HashExp{"requester": userId},
+			dbx.HashExp{"addressee": userId},
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(friendships))
+	for _, friendship := range friendships {
+		ids = append(ids, friendshipCounterpart(friendship, userId))
+	}
+
+	return ids, nil
+}
@@ -3,61 +3,124 @@ package handlers
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/bogdancanciu/frekathon-backend/interests"
 	"github.com/labstack/echo/v5"
 	"github.com/pocketbase/pocketbase/apis"
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxInterests = 10
+
+	// interestSimilarityThreshold lets an interest match its parent and
+	// sub-interests of the same parent, but not mere category neighbours.
+	interestSimilarityThreshold = 0.6
 )
 
 func BindInterestsHooks(app core.App) {
+	trending := &trendingCache{}
+
+	app.OnBeforeServe().Add(GetInterestsCatalog(app))
+	app.OnBeforeServe().Add(GetTrendingInterests(app, trending))
+	app.OnBeforeServe().Add(ScheduleTrendingInterests(app, trending))
 	app.OnBeforeServe().Add(SetInterests(app))
 }
 
-func SetInterests(app core.App) func(e *core.ServeEvent) error {
+func GetInterestsCatalog(app core.App) func(e *core.ServeEvent) error {
 	return func(e *core.ServeEvent) error {
-		e.Router.POST("/api/interests", func(c echo.Context) error {
-			session := getSessionToken(c.Request())
-			userId, sessionErr := UserIdFromSession(session)
-			if sessionErr != nil {
-				return sessionErr
+		apiRoutes(app, e).GET("/interests/catalog", func(c echo.Context) error {
+			catalog, err := loadInterestCatalog(app.Dao())
+			if err != nil {
+				log.Println("Failed to load the interests catalog", err)
+				return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
 			}
 
+			return c.JSON(http.StatusOK, map[string]any{"items": catalog.Entries()})
+		})
+		return nil
+	}
+}
+
+func SetInterests(app core.App) func(e *core.ServeEvent) error {
+	return func(e *core.ServeEvent) error {
+		apiRoutes(app, e).POST("/interests", func(c echo.Context) error {
+			userRecord := CurrentUser(c)
+
 			reqBody, err := readBody(c.Request())
 			if err != nil {
 				log.Println("Failed to read request body", err)
 				return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
 			}
 
-			interests, err := decodeInterests(reqBody)
+			values, err := decodeInterests(reqBody)
 			if err != nil {
-				return apis.NewApiError(http.StatusInternalServerError, err.Error(), "")
+				return apis.NewBadRequestError("Malformed body.", "")
 			}
 
-			userRecord, err := app.Dao().FindRecordById("users", userId)
-			userRecord.Set("interests", interests)
+			catalog, err := loadInterestCatalog(app.Dao())
+			if err != nil {
+				log.Println("Failed to load the interests catalog", err)
+				return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+			}
+
+			slugs, unknown := catalog.ResolveAll(values)
+			if len(unknown) > 0 {
+				return apis.NewBadRequestError("Unknown interests: "+strings.Join(unknown, ", ")+".", "")
+			}
+			if len(slugs) > maxInterests {
+				return apis.NewBadRequestError("Pick at most "+strconv.Itoa(maxInterests)+" interests.", "")
+			}
+
+			userRecord.Set("interests", slugs)
 
 			if err := app.Dao().SaveRecord(userRecord); err != nil {
 				return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
 			}
 
-			return nil
+			return c.JSON(http.StatusOK, map[string][]string{"interests": slugs})
 		})
 		return nil
 	}
 }
 
 func decodeInterests(body []byte) ([]string, error) {
-	var bodyData map[string][]string
-	err := json.Unmarshal(body, &bodyData)
-	if err != nil {
+	var bodyData struct {
+		Interests *[]string `json:"interests"`
+	}
+	if err := json.Unmarshal(body, &bodyData); err != nil {
 		return nil, err
 	}
 
-	interests, found := bodyData["interests"]
-	if !found {
+	if bodyData.Interests == nil {
 		return nil, fmt.Errorf("Malformed body")
 	}
 
-	return interests, nil
+	return *bodyData.Interests, nil
+}
+
+// loadInterestCatalog reads the interests collection into a catalog.
+func loadInterestCatalog(dao *daos.Dao) (*interests.Catalog, error) {
+	records, err := dao.FindRecordsByExpr("interests")
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]interests.Entry, 0, len(records))
+	for _, record := range records {
+		entries = append(entries, interests.Entry{
+			Slug:     record.GetString("slug"),
+			Name:     record.GetString("name"),
+			Category: record.GetString("category"),
+			Emoji:    record.GetString("emoji"),
+			Parent:   record.GetString("parent"),
+			Synonyms: record.GetStringSlice("synonyms"),
+		})
+	}
+
+	return interests.NewCatalog(entries), nil
 }
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLoadInterestCatalog(t *testing.T) {
+	app := newTestApp(t)
+
+	catalog, err := loadInterestCatalog(app.Dao())
+	assert.NoError(t, err)
+
+	t.Run("should load the seeded catalog", func(t *testing.T) {
+		entry, ok := catalog.Entry("hiking")
+		assert.True(t, ok)
+		assert.Equal(t, "Hiking", entry.Name)
+		assert.Contains(t, entry.Synonyms, "trekking")
+	})
+
+	t.Run("should resolve free text onto catalog slugs", func(t *testing.T) {
+		slugs, unknown := catalog.ResolveAll([]string{"Hike", "soccer", "Video Games", "underwater basket weaving"})
+		assert.Equal(t, []string{"hiking", "football", "video_games"}, slugs)
+		assert.Equal(t, []string{"underwater basket weaving"}, unknown)
+	})
+}
+
+func TestMatchExistingUsers(t *testing.T) {
+	app := newTestApp(t)
+
+	var userIds []string
+	for _, interest := range []string{"bouldering", "climbing", "sport_climbing"} {
+		user := newTestUser(t, app)
+		userIds = append(userIds, user.Id)
+		_, err := app.Dao().DB().
+			Insert("chat_finder", dbx.Params{"id": user.Id, "user_id": user.Id, "interests": `["` + interest + `"]`}).
+			Execute()
+		assert.NoError(t, err)
+	}
+
+	t.Run("should group users with related interests", func(t *testing.T) {
+		assert.NoError(t, matchExistingUsers(app))
+
+		chats, err := findRecordsContaining(app.Dao(), "chats", "participants", userIds[0])
+		assert.NoError(t, err)
+		if assert.Len(t, chats, 1) {
+			assert.ElementsMatch(t, userIds, chats[0].GetStringSlice("participants"))
+			assert.Equal(t, []string{"climbing"}, chats[0].GetStringSlice("common_interests"))
+		}
+	})
+}
@@ -1,32 +1,390 @@
 package handlers
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/labstack/echo/v5"
 	"github.com/pocketbase/pocketbase/apis"
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/forms"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"log"
 	"net/http"
+	"strings"
 )
 
+const (
+	VisibilityEveryone = "everyone"
+	VisibilityFriends  = "friends"
+	VisibilityNobody   = "nobody"
+)
+
+// defaultPrivacy lists the profile fields with a privacy setting and the
+// visibility used until the user changes it.
+var defaultPrivacy = map[string]string{
+	"name":      VisibilityEveryone,
+	"avatar":    VisibilityEveryone,
+	"bio":       VisibilityEveryone,
+	"interests": VisibilityFriends,
+}
+
+type ownProfile struct {
+	ID              string            `json:"id"`
+	Username        string            `json:"username"`
+	Email           string            `json:"email"`
+	Name            string            `json:"name"`
+	Tag             string            `json:"tag"`
+	Avatar          string            `json:"avatar"`
+	Bio             string            `json:"bio"`
+	Interests       []string          `json:"interests"`
+	LegacyInterests []string          `json:"legacy_interests,omitempty"`
+	Privacy         map[string]string `json:"privacy"`
+	Created         types.DateTime    `json:"created"`
+}
+
+// profileUpdate holds the editable profile fields, sent as JSON or, to
+// upload an avatar, as multipart form data. Nil fields are left unchanged.
+type profileUpdate struct {
+	Name         *string `json:"name" form:"name"`
+	Bio          *string `json:"bio" form:"bio"`
+	Tag          *string `json:"tag" form:"tag"`
+	RerollTag    bool    `json:"reroll_tag" form:"reroll_tag"`
+	RemoveAvatar bool    `json:"remove_avatar" form:"remove_avatar"`
+}
+
+// publicProfile leaves out the fields the viewer is not allowed to see.
+type publicProfile struct {
+	ID           string   `json:"id"`
+	Tag          string   `json:"tag"`
+	Name         string   `json:"name,omitempty"`
+	Avatar       string   `json:"avatar,omitempty"`
+	Bio          string   `json:"bio,omitempty"`
+	Interests    []string `json:"interests,omitempty"`
+	Relationship string   `json:"relationship"`
+}
+
 func BindProfileHooks(app core.App) {
 	app.OnBeforeServe().Add(GetProfile(app))
+	app.OnBeforeServe().Add(UpdateProfile(app))
+	app.OnBeforeServe().Add(GetDataExport(app))
+	app.OnBeforeServe().Add(GetPublicProfile(app))
+	app.OnBeforeServe().Add(SetPrivacy(app))
 }
 
 func GetProfile(app core.App) func(e *core.ServeEvent) error {
 	return func(e *core.ServeEvent) error {
-		e.Router.GET("/api/profile", func(c echo.Context) error {
-			session := getSessionToken(c.Request())
-			userId, sessionErr := UserIdFromSession(session)
-			if sessionErr != nil {
-				return sessionErr
+		apiRoutes(app, e).GET("/profile", func(c echo.Context) error {
+			return c.JSON(http.StatusOK, newOwnProfile(CurrentUser(c)))
+		})
+		return nil
+	}
+}
+
+func GetPublicProfile(app core.App) func(e *core.ServeEvent) error {
+	return func(e *core.ServeEvent) error {
+		apiRoutes(app, e).GET("/profile/:id_or_tag", func(c echo.Context) error {
+			viewer := CurrentUser(c)
+
+			userRecord, err := findUserByIdOrTag(app.Dao(), c.PathParam("id_or_tag"))
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return apis.NewNotFoundError("User not found.", "")
+				}
+				return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+			}
+
+			if userRecord.Id == viewer.Id {
+				return c.JSON(http.StatusOK, newOwnProfile(viewer))
 			}
 
-			userRecord, err := app.Dao().FindRecordById("users", userId)
+			blocked, err := isBlocked(app.Dao(), viewer.Id, userRecord.Id)
 			if err != nil {
 				return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
 			}
+			if blocked {
+				return apis.NewNotFoundError("User not found.", "")
+			}
 
-			return c.JSON(http.StatusOK, userRecord)
+			relationship, err := relationshipWith(app.Dao(), viewer.Id, userRecord.Id)
+			if err != nil {
+				return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+			}
+
+			return c.JSON(http.StatusOK, newPublicProfile(userRecord, relationship))
 		})
 		return nil
 	}
 }
+
+func UpdateProfile(app core.App) func(e *core.ServeEvent) error {
+	return func(e *core.ServeEvent) error {
+		apiRoutes(app, e).PATCH("/profile", func(c echo.Context) error {
+			userRecord := CurrentUser(c)
+
+			var changes profileUpdate
+			if err := c.Bind(&changes); err != nil {
+				return apis.NewBadRequestError("Malformed body.", "")
+			}
+
+			var avatar *filesystem.File
+			if header, err := c.FormFile("avatar"); err == nil {
+				avatar, err = filesystem.NewFileFromMultipart(header)
+				if err != nil {
+					return apis.NewBadRequestError("Failed to read the avatar.", "")
+				}
+			} else if !errors.Is(err, http.ErrMissingFile) && !errors.Is(err, http.ErrNotMultipart) {
+				return apis.NewBadRequestError("Failed to read the avatar.", "")
+			}
+
+			if err := updateProfile(app, userRecord, changes, avatar); err != nil {
+				return err
+			}
+
+			return c.JSON(http.StatusOK, newOwnProfile(userRecord))
+		})
+		return nil
+	}
+}
+
+// updateProfile validates and saves the changes through the record form, so
+// the schema rules apply and the avatar goes through the file storage.
+func updateProfile(app core.App, userRecord *models.Record, changes profileUpdate, avatar *filesystem.File) *apis.ApiError {
+	data := map[string]any{}
+	if changes.Name != nil {
+		data["name"] = strings.TrimSpace(*changes.Name)
+	}
+	if changes.Bio != nil {
+		data["bio"] = strings.TrimSpace(*changes.Bio)
+	}
+
+	switch {
+	case changes.Tag != nil && changes.RerollTag:
+		return apis.NewBadRequestError("Either claim a tag or re-roll it, not both.", "")
+	case changes.Tag != nil:
+		tag := strings.ToLower(strings.TrimSpace(*changes.Tag))
+		if !customTagPattern.MatchString(tag) {
+			return apis.NewBadRequestError("Tags are 3 to 32 lowercase letters, digits, dots or underscores.", "")
+		}
+
+		taken, err := tagTaken(app.Dao(), tag, userRecord.Id)
+		if err != nil {
+			return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+		}
+		if taken {
+			return apis.NewApiError(http.StatusConflict, "Tag is already taken.", "")
+		}
+		data["tag"] = tag
+	case changes.RerollTag:
+		tag, err := generateUniqueTag(app.Dao())
+		if err != nil {
+			log.Println("Failed to generate a tag", err)
+			return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+		}
+		data["tag"] = tag
+	}
+
+	form := forms.NewRecordUpsert(app, userRecord)
+	if err := form.LoadData(data); err != nil {
+		return apis.NewBadRequestError("Failed to update the profile.", err)
+	}
+
+	if changes.RemoveAvatar {
+		if err := form.RemoveFiles("avatar"); err != nil {
+			return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+		}
+	}
+	if avatar != nil {
+		if err := form.AddFiles("avatar", avatar); err != nil {
+			return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+		}
+	}
+
+	if err := form.Submit(); err != nil {
+		var validationErrors validation.Errors
+		switch {
+		case errors.As(err, &validationErrors):
+			return apis.NewBadRequestError("Failed to update the profile.", validationErrors)
+		case isTagConflict(err):
+			return apis.NewApiError(http.StatusConflict, "Tag is already taken.", "")
+		default:
+			log.Println("Failed to update profile", err)
+			return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+		}
+	}
+
+	return nil
+}
+
+func SetPrivacy(app core.App) func(e *core.ServeEvent) error {
+	return func(e *core.ServeEvent) error {
+		apiRoutes(app, e).PUT("/profile/privacy", func(c echo.Context) error {
+			userRecord := CurrentUser(c)
+
+			var changes map[string]string
+			if err := c.Bind(&changes); err != nil {
+				return apis.NewBadRequestError("Malformed body.", "")
+			}
+
+			privacy := privacySettings(userRecord)
+			for field, visibility := range changes {
+				if _, ok := defaultPrivacy[field]; !ok {
+					return apis.NewBadRequestError("Unknown profile field "+field+".", "")
+				}
+				if visibility != VisibilityEveryone && visibility != VisibilityFriends && visibility != VisibilityNobody {
+					return apis.NewBadRequestError("Visibility must be one of everyone, friends or nobody.", "")
+				}
+				privacy[field] = visibility
+			}
+
+			userRecord.Set("privacy", privacy)
+			if err := app.Dao().SaveRecord(userRecord); err != nil {
+				log.Println("Failed to save privacy settings", err)
+				return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+			}
+
+			return c.JSON(http.StatusOK, privacy)
+		})
+		return nil
+	}
+}
+
+func newOwnProfile(userRecord *models.Record) ownProfile {
+	return ownProfile{
+		ID:              userRecord.Id,
+		Username:        userRecord.Username(),
+		Email:           userRecord.Email(),
+		Name:            userRecord.GetString("name"),
+		Tag:             userRecord.GetString("tag"),
+		Avatar:          avatarURL(userRecord.Id, userRecord.GetString("avatar")),
+		Bio:             userRecord.GetString("bio"),
+		Interests:       userInterests(userRecord),
+		LegacyInterests: userRecord.GetStringSlice("legacy_interests"),
+		Privacy:         privacySettings(userRecord),
+		Created:         userRecord.Created,
+	}
+}
+
+func newPublicProfile(userRecord *models.Record, relationship string) publicProfile {
+	privacy := privacySettings(userRecord)
+	visible := func(field string) bool {
+		return isVisible(privacy, field, relationship)
+	}
+
+	profile := publicProfile{
+		ID:           userRecord.Id,
+		Tag:          userRecord.GetString("tag"),
+		Relationship: relationship,
+	}
+	if visible("name") {
+		profile.Name = userRecord.GetString("name")
+	}
+	if visible("avatar") {
+		profile.Avatar = avatarURL(userRecord.Id, userRecord.GetString("avatar"))
+	}
+	if visible("bio") {
+		profile.Bio = userRecord.GetString("bio")
+	}
+	if visible("interests") {
+		profile.Interests = userInterests(userRecord)
+	}
+
+	return profile
+}
+
+// privacySettings returns the user's visibility for every field with a
+// privacy setting, falling back to defaultPrivacy.
+func privacySettings(userRecord *models.Record) map[string]string {
+	raw, _ := userRecord.Get("privacy").(types.JsonRaw)
+	return parsePrivacy(raw)
+}
+
+// parsePrivacy reads stored privacy settings, falling back to
+// defaultPrivacy.
+func parsePrivacy(raw types.JsonRaw) map[string]string {
+	privacy := map[string]string{}
+	if len(raw) > 0 {
+		// unreadable settings fall back to the defaults
+		_ = json.Unmarshal(raw, &privacy)
+	}
+
+	for field, visibility := range defaultPrivacy {
+		if _, ok := privacy[field]; !ok {
+			privacy[field] = visibility
+		}
+	}
+	for field := range privacy {
+		if _, ok := defaultPrivacy[field]; !ok {
+			delete(privacy, field)
+		}
+	}
+
+	return privacy
+}
+
+// isVisible reports whether a viewer with the given relationship may see the
+// field.
+func isVisible(privacy map[string]string, field, relationship string) bool {
+	switch privacy[field] {
+	case VisibilityEveryone:
+		return true
+	case VisibilityFriends:
+		return relationship == RelationshipFriend
+	default:
+		return false
+	}
+}
+
+// visibleExp is the SQL counterpart of isVisible for the users table aliased
+// as alias. The viewer's friend IDs are bound as a JSON array to {:friends}.
+func visibleExp(alias, field string) string {
+	setting := "COALESCE(CASE WHEN json_valid(" + alias + ".privacy) THEN json_extract(" + alias + ".privacy, '$." + field + "') END, '" + defaultPrivacy[field] + "')"
+
+	return "(" + setting + " = '" + VisibilityEveryone + "' OR (" + setting + " = '" + VisibilityFriends + "' AND " +
+		alias + ".id IN (SELECT value FROM json_each({:friends}))))"
+}
+
+func userInterests(userRecord *models.Record) []string {
+	interests := []string{}
+	if raw, ok := userRecord.Get("interests").(types.JsonRaw); ok && len(raw) > 0 {
+		if err := json.Unmarshal(raw, &interests); err != nil || interests == nil {
+			return []string{}
+		}
+	}
+
+	return interests
+}
+
+func findUserByIdOrTag(dao *daos.Dao, idOrTag string) (*models.Record, error) {
+	userRecord, err := dao.FindRecordById("users", idOrTag)
+	if err == nil || !errors.Is(err, sql.ErrNoRows) {
+		return userRecord, err
+	}
+
+	return dao.FindFirstRecordByData("users", "tag", idOrTag)
+}
+
+// relationshipWith returns the relationship status of the user with the
+// other user.
+func relationshipWith(dao *daos.Dao, userId, otherId string) (string, error) {
+	friendship, err := findFriendship(dao, userId, otherId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return RelationshipNone, nil
+		}
+		return "", err
+	}
+
+	switch friendship.GetString("status") {
+	case FriendshipAccepted:
+		return RelationshipFriend, nil
+	case FriendshipPending:
+		return RelationshipPending, nil
+	default:
+		return RelationshipNone, nil
+	}
+}
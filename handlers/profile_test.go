@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestPublicProfile(t *testing.T) {
+	app := newTestApp(t)
+	user := newTestUser(t, app)
+	user.Set("tag", "test_tag")
+	user.Set("interests", []string{"climbing"})
+	assert.NoError(t, app.Dao().SaveRecord(user))
+
+	t.Run("should find users by ID or tag", func(t *testing.T) {
+		byId, err := findUserByIdOrTag(app.Dao(), user.Id)
+		assert.NoError(t, err)
+		byTag, err := findUserByIdOrTag(app.Dao(), "test_tag")
+		assert.NoError(t, err)
+		assert.Equal(t, byId.Id, byTag.Id)
+	})
+
+	t.Run("should show interests to friends only by default", func(t *testing.T) {
+		stranger := newPublicProfile(user, RelationshipNone)
+		assert.Equal(t, "Test User", stranger.Name)
+		assert.Empty(t, stranger.Interests)
+
+		friend := newPublicProfile(user, RelationshipFriend)
+		assert.Equal(t, []string{"climbing"}, friend.Interests)
+	})
+
+	t.Run("should apply the user's privacy settings", func(t *testing.T) {
+		user.Set("privacy", map[string]string{"name": VisibilityNobody, "interests": VisibilityEveryone})
+
+		profile := newPublicProfile(user, RelationshipFriend)
+		assert.Empty(t, profile.Name)
+		assert.Equal(t, []string{"climbing"}, profile.Interests)
+		assert.Equal(t, "test_tag", profile.Tag)
+	})
+
+	t.Run("should include the email and privacy settings in the own profile", func(t *testing.T) {
+		own := newOwnProfile(user)
+		assert.Equal(t, user.Email(), own.Email)
+		assert.Equal(t, VisibilityEveryone, own.Privacy["avatar"])
+	})
+
+	t.Run("should report the relationship with the viewer", func(t *testing.T) {
+		other := newTestUser(t, app)
+		relationship, err := relationshipWith(app.Dao(), user.Id, other.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, RelationshipNone, relationship)
+
+		assert.NoError(t, runFriendsTransaction(app, func(txDao *daos.Dao) error {
+			return sendInvite(txDao, other.Id, user.Id)
+		}))
+		relationship, err = relationshipWith(app.Dao(), user.Id, other.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, RelationshipPending, relationship)
+	})
+}
+
+func TestUpdateProfile(t *testing.T) {
+	app := newTestApp(t)
+	user := newTestUser(t, app)
+	other := newTestUser(t, app)
+	other.Set("tag", "taken_tag")
+	assert.NoError(t, app.Dao().SaveRecord(other))
+
+	text := func(value string) *string { return &value }
+
+	t.Run("should update the name and bio", func(t *testing.T) {
+		assert.Nil(t, updateProfile(app, user, profileUpdate{Name: text("Jane Doe"), Bio: text(" Climber ")}, nil))
+
+		saved, err := app.Dao().FindRecordById("users", user.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, "Jane Doe", saved.GetString("name"))
+		assert.Equal(t, "Climber", saved.GetString("bio"))
+	})
+
+	t.Run("should validate fields against the schema", func(t *testing.T) {
+		err := updateProfile(app, user, profileUpdate{Name: text("not a name")}, nil)
+		if assert.NotNil(t, err) {
+			assert.Equal(t, http.StatusBadRequest, err.Code)
+		}
+	})
+
+	t.Run("should claim a free tag only", func(t *testing.T) {
+		err := updateProfile(app, user, profileUpdate{Tag: text("Taken_Tag")}, nil)
+		if assert.NotNil(t, err) {
+			assert.Equal(t, http.StatusConflict, err.Code)
+		}
+
+		assert.Nil(t, updateProfile(app, user, profileUpdate{Tag: text("my.tag")}, nil))
+		assert.Equal(t, "my.tag", user.GetString("tag"))
+	})
+
+	t.Run("should re-roll the tag", func(t *testing.T) {
+		assert.Nil(t, updateProfile(app, user, profileUpdate{RerollTag: true}, nil))
+		assert.NotEqual(t, "my.tag", user.GetString("tag"))
+	})
+
+	t.Run("should store and remove the avatar", func(t *testing.T) {
+		avatar, err := filesystem.NewFileFromBytes(testPNG, "avatar.png")
+		assert.NoError(t, err)
+
+		assert.Nil(t, updateProfile(app, user, profileUpdate{}, avatar))
+		assert.NotEmpty(t, user.GetString("avatar"))
+		assert.Contains(t, newOwnProfile(user).Avatar, "/api/files/_pb_users_auth_/"+user.Id+"/")
+
+		assert.Nil(t, updateProfile(app, user, profileUpdate{RemoveAvatar: true}, nil))
+		assert.Empty(t, user.GetString("avatar"))
+	})
+}
+
+// testPNG is a 1x1 transparent PNG.
+var testPNG = []byte{
+	0x89, 0x50, 0x4e, 0x47, 0x0d, 0x0a, 0x1a, 0x0a, 0x00, 0x00, 0x00, 0x0d, 0x49, 0x48, 0x44, 0x52,
+	0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x08, 0x06, 0x00, 0x00, 0x00, 0x1f, 0x15, 0xc4,
+	0x89, 0x00, 0x00, 0x00, 0x0d, 0x49, 0x44, 0x41, 0x54, 0x78, 0x9c, 0x63, 0x00, 0x01, 0x00, 0x00,
+	0x05, 0x00, 0x01, 0x0d, 0x0a, 0x2d, 0xb4, 0x00, 0x00, 0x00, 0x00, 0x49, 0x45, 0x4e, 0x44, 0xae,
+	0x42, 0x60, 0x82,
+}
@@ -15,11 +15,27 @@ const (
 	maxMessageSize = 512
 )
 
+// Close codes sent when the hub drops a socket because of its session, so
+// clients know to re-authenticate before reconnecting.
+const (
+	CloseSessionExpired = 4001
+	CloseSessionRevoked = 4002
+	CloseAccountDeleted = 4003
+)
+
+// Notifications aren't tied to a chat, they carry the notification kind and
+// come from notificationSender.
+const (
+	messageKindNotification = "notification"
+	notificationSender      = "System"
+)
+
 type socketMessage struct {
 	ChatId    string `json:"chat_id"`
 	Sender    string `json:"sender"`
 	Content   string `json:"message"`
 	Timestamp int64  `json:"timestamp"`
+	Kind      string `json:"kind,omitempty"`
 }
 
 type chatUser struct {
@@ -33,16 +49,27 @@ func newChatUser(id, name, tag string) *chatUser {
 }
 
 type Client struct {
-	chatUser *chatUser
-	hub      *Hub
-	conn     *websocket.Conn
-	send     chan []byte
+	chatUser         *chatUser
+	hub              *Hub
+	conn             *websocket.Conn
+	send             chan []byte
+	sessionVersion   int
+	sessionExpiresAt time.Time
 }
 
 func (c *Client) ID() string {
 	return c.chatUser.id
 }
 
+// closeSession sends a close frame with the given code ahead of the hub
+// unregistering the client.
+func (c *Client) closeSession(code int, reason string) {
+	closeMessage := websocket.FormatCloseMessage(code, reason)
+	if err := c.conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(writeWait)); err != nil {
+		log.Printf("failed to send socket close message: %v", err)
+	}
+}
+
 func (c *Client) readPump() {
 	defer func() {
 		c.hub.unregister <- c
@@ -69,6 +96,8 @@ func (c *Client) readPump() {
 
 		msg.Sender = c.chatUser.id
 		msg.Timestamp = time.Now().Unix()
+		// only the hub sends notifications
+		msg.Kind = ""
 
 		c.hub.broadcast <- msg
 	}
@@ -2,38 +2,112 @@ package protocol
 
 import (
 	"encoding/json"
+	"github.com/bogdancanciu/frekathon-backend/handlers"
 	"github.com/pocketbase/pocketbase/core"
 	"github.com/pocketbase/pocketbase/models"
 	"github.com/pocketbase/pocketbase/tools/types"
 	"log"
+	"sync"
+	"time"
 )
 
+const sessionCheckPeriod = time.Minute
+
+// userUpdate carries the user fields connected clients keep a copy of.
+type userUpdate struct {
+	userId  string
+	version int
+	name    string
+	tag     string
+	deleted bool
+}
+
+// notification is a message from the app itself rather than a chat
+// participant.
+type notification struct {
+	userId  string
+	message string
+}
+
 type Hub struct {
 	app        core.App
 	msgStore   map[string][]socketMessage
+	clientsMu  sync.RWMutex
 	clients    map[string]*Client
 	broadcast  chan socketMessage
 	register   chan *Client
 	unregister chan *Client
+	users      chan userUpdate
+	notify     chan notification
 }
 
 func NewHub(app core.App) *Hub {
-	return &Hub{
+	hub := &Hub{
 		app:        app,
 		broadcast:  make(chan socketMessage),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		users:      make(chan userUpdate),
+		notify:     make(chan notification),
 		clients:    make(map[string]*Client),
 		msgStore:   make(map[string][]socketMessage),
 	}
+
+	// revoking sessions bumps the user's token version, drop the sockets
+	// opened with older tokens as soon as the record is saved, and refresh
+	// the name and tag shown to the other chat participants
+	app.OnModelAfterUpdate("users").Add(func(e *core.ModelEvent) error {
+		if userRecord, ok := e.Model.(*models.Record); ok {
+			update := userUpdate{
+				userId:  userRecord.Id,
+				version: handlers.SessionVersion(userRecord),
+				name:    userRecord.GetString("name"),
+				tag:     userRecord.GetString("tag"),
+			}
+			// don't block the saving goroutine, it could be the hub itself
+			go func() { hub.users <- update }()
+		}
+		return nil
+	})
+	app.OnModelAfterDelete("users").Add(func(e *core.ModelEvent) error {
+		update := userUpdate{userId: e.Model.GetId(), deleted: true}
+		go func() { hub.users <- update }()
+		return nil
+	})
+
+	return hub
 }
 
 func (h *Hub) Run() {
+	sessionTicker := time.NewTicker(sessionCheckPeriod)
+	defer sessionTicker.Stop()
+
 	for {
 		select {
+		case update := <-h.users:
+			client, ok := h.clients[update.userId]
+			if !ok {
+				continue
+			}
+			if update.deleted {
+				h.disconnect(client, CloseAccountDeleted, "account deleted")
+				continue
+			}
+			if client.sessionVersion < update.version {
+				h.disconnect(client, CloseSessionRevoked, "session revoked")
+				continue
+			}
+			client.chatUser.name = update.name
+			client.chatUser.tag = update.tag
+		case now := <-sessionTicker.C:
+			for _, client := range h.clients {
+				if now.After(client.sessionExpiresAt) {
+					h.disconnect(client, CloseSessionExpired, "session expired")
+				}
+			}
 		case client := <-h.register:
-			h.clients[client.ID()] = client
-			messageRecord, err := h.app.Dao().FindFirstRecordByData("messages", "user_id", client.ID())
+			h.addClient(client)
+			messageRecord, err := handlers.FindCompanionRecord(h.app.Dao(), "messages", client.ID())
 			if err != nil {
 				log.Println("Error finding messages record", err)
 				continue
@@ -55,8 +129,8 @@ func (h *Hub) Run() {
 				continue
 			}
 		case client := <-h.unregister:
-			if _, ok := h.clients[client.ID()]; ok {
-				delete(h.clients, client.ID())
+			if registered, ok := h.clients[client.ID()]; ok && registered == client {
+				h.removeClient(client)
 				close(client.send)
 			}
 		case message := <-h.broadcast:
@@ -66,45 +140,114 @@ func (h *Hub) Run() {
 				continue
 			}
 
+			if chatRecord.GetBool("archived") {
+				continue
+			}
+
 			chatParticipants := chatRecord.GetStringSlice("participants")
 			for _, participant := range chatParticipants {
 				if participant == message.Sender {
 					continue
 				}
+
+				silenced, err := handlers.IsSilenced(h.app.Dao(), participant, message.Sender)
+				if err != nil {
+					log.Println("Failed to check blocked users", err)
+					continue
+				}
+				if silenced {
+					continue
+				}
+
 				msgBytes, err := h.marshalSocketMessage(message, chatRecord)
 				if err != nil {
 					log.Println("Failed to serialize socket message", err)
 					continue
 				}
 
-				if _, ok := h.clients[participant]; ok {
-					h.clients[participant].send <- msgBytes
-				} else {
-					messageRecord, err := h.app.Dao().FindFirstRecordByData("messages", "user_id", participant)
-					if err != nil {
-						log.Println("Error finding messages record", err)
-						continue
-					}
-
-					pendingMessages, err := h.getPendingMessages(messageRecord)
-					if err != nil {
-						log.Println("Failed to fetch pending messages", err)
-						continue
-					}
-
-					pendingMessages = append(pendingMessages, msgBytes)
-					messageRecord.Set("messages", pendingMessages)
-					if err := h.app.Dao().SaveRecord(messageRecord); err != nil {
-						log.Println("Failed to store pending message for offline user", err)
-						continue
-					}
-				}
+				h.deliver(participant, msgBytes)
+			}
+		case notification := <-h.notify:
+			msgBytes, err := json.Marshal(socketMessage{
+				Sender:    notificationSender,
+				Content:   notification.message,
+				Timestamp: time.Now().Unix(),
+				Kind:      messageKindNotification,
+			})
+			if err != nil {
+				log.Println("Failed to serialize notification", err)
+				continue
 			}
 
+			h.deliver(notification.userId, msgBytes)
 		}
 	}
 }
 
+// Notify sends the message to the user as a notification. It doesn't block,
+// handlers may call it while the hub is busy.
+func (h *Hub) Notify(userId, message string) {
+	go func() { h.notify <- notification{userId: userId, message: message} }()
+}
+
+// deliver sends the message to the user's socket, or stores it with their
+// pending messages while they are offline.
+func (h *Hub) deliver(userId string, msgBytes []byte) {
+	if client, ok := h.clients[userId]; ok {
+		client.send <- msgBytes
+		return
+	}
+
+	messageRecord, err := handlers.FindCompanionRecord(h.app.Dao(), "messages", userId)
+	if err != nil {
+		log.Println("Error finding messages record", err)
+		return
+	}
+
+	pendingMessages, err := h.getPendingMessages(messageRecord)
+	if err != nil {
+		log.Println("Failed to fetch pending messages", err)
+		return
+	}
+
+	pendingMessages = append(pendingMessages, msgBytes)
+	messageRecord.Set("messages", pendingMessages)
+	if err := h.app.Dao().SaveRecord(messageRecord); err != nil {
+		log.Println("Failed to store pending message for offline user", err)
+	}
+}
+
+// IsOnline reports whether the user currently has a connected socket.
+func (h *Hub) IsOnline(userId string) bool {
+	h.clientsMu.RLock()
+	defer h.clientsMu.RUnlock()
+
+	_, ok := h.clients[userId]
+	return ok
+}
+
+// addClient and removeClient are the only writers of the clients map, they
+// run on the hub goroutine and lock so IsOnline can be called from handlers.
+func (h *Hub) addClient(client *Client) {
+	h.clientsMu.Lock()
+	defer h.clientsMu.Unlock()
+
+	h.clients[client.ID()] = client
+}
+
+func (h *Hub) removeClient(client *Client) {
+	h.clientsMu.Lock()
+	defer h.clientsMu.Unlock()
+
+	delete(h.clients, client.ID())
+}
+
+func (h *Hub) disconnect(client *Client, code int, reason string) {
+	client.closeSession(code, reason)
+	h.removeClient(client)
+	close(client.send)
+}
+
 func (h *Hub) removePendingMessages(pmRecord *models.Record) error {
 	pmRecord.Set("messages", [][]byte{})
 	if err := h.app.Dao().SaveRecord(pmRecord); err != nil {
@@ -1,58 +1,121 @@
 package protocol
 
 import (
-	"errors"
 	"github.com/bogdancanciu/frekathon-backend/handlers"
 	"github.com/pocketbase/pocketbase/apis"
 	"github.com/pocketbase/pocketbase/core"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
 
 	"github.com/gorilla/websocket"
 )
 
+const (
+	// sessionSubprotocol is offered by browser clients, which cannot set headers
+	// on the upgrade request, together with the session token as a second
+	// subprotocol: `Sec-WebSocket-Protocol: session-token, <token>`.
+	sessionSubprotocol = "session-token"
+	sessionQueryParam  = "token"
+	allowedOriginsEnv  = "WS_ALLOWED_ORIGINS"
+)
+
+var allowedOrigins = originsFromEnv()
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		// Allow connections from all origins
-		return true
-	},
+	Subprotocols:    []string{sessionSubprotocol},
+	CheckOrigin:     checkOrigin,
 }
 
-func ServeWs(app core.App, hub *Hub, w http.ResponseWriter, r *http.Request) {
+func ServeWs(app core.App, hub *Hub, w http.ResponseWriter, r *http.Request) error {
+	if !checkOrigin(r) {
+		return apis.NewForbiddenError("Origin not allowed.", "")
+	}
+
+	session, sessionErr := handlers.SessionFromToken(app, wsSessionToken(r))
+	if sessionErr != nil {
+		return sessionErr
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println(err)
-		return
+		// the upgrader has already replied with an HTTP error
+		log.Println("Failed to upgrade websocket connection", err)
+		return nil
 	}
 
-	_, sessionToken, err := conn.ReadMessage()
-	if err != nil {
-		log.Println("Error reading initial message:", err)
-		conn.Close()
-		return
+	userRecord := session.User
+	chatUser := newChatUser(userRecord.Id, userRecord.GetString("name"), userRecord.GetString("tag"))
+
+	client := &Client{
+		chatUser:         chatUser,
+		hub:              hub,
+		conn:             conn,
+		send:             make(chan []byte, 256),
+		sessionVersion:   session.Version,
+		sessionExpiresAt: session.ExpiresAt,
 	}
+	client.hub.register <- client
+
+	go client.writePump()
+	go client.readPump()
+
+	return nil
+}
 
-	userId, err := handlers.UserIdFromSession(string(sessionToken))
-	if !errors.Is(err, (*apis.ApiError)(nil)) {
-		log.Println("Failed to decode session token", err)
-		conn.Close()
-		return
+func wsSessionToken(r *http.Request) string {
+	if sessionToken := handlers.GetSessionToken(r); sessionToken != "" {
+		return sessionToken
 	}
 
-	userRecord, err := app.Dao().FindFirstRecordByData("users", "id", userId)
+	protocols := websocket.Subprotocols(r)
+	for i, protocol := range protocols {
+		if protocol == sessionSubprotocol && i+1 < len(protocols) {
+			return protocols[i+1]
+		}
+	}
+
+	return r.URL.Query().Get(sessionQueryParam)
+}
+
+// checkOrigin accepts requests without an Origin header (non-browser clients),
+// same-origin requests and origins listed in WS_ALLOWED_ORIGINS.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	originURL, err := url.Parse(origin)
 	if err != nil {
-		log.Println("error finding user record", err)
-		conn.Close()
-		return
+		return false
+	}
+
+	if strings.EqualFold(originURL.Host, r.Host) {
+		return true
 	}
 
-	chatUser := newChatUser(userId, userRecord.GetString("name"), userRecord.GetString("tag"))
+	for _, allowed := range allowedOrigins {
+		if strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
 
-	client := &Client{chatUser: chatUser, hub: hub, conn: conn, send: make(chan []byte, 256)}
-	client.hub.register <- client
+	return false
+}
 
-	go client.writePump()
-	go client.readPump()
+func originsFromEnv() []string {
+	var origins []string
+	for _, origin := range strings.Split(os.Getenv(allowedOriginsEnv), ",") {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		if origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+
+	return origins
 }
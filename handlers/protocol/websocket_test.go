@@ -0,0 +1,85 @@
+package protocol
+
+import (
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWsSessionToken(t *testing.T) {
+	t.Run("should read the session-token header", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/ws?token=query", nil)
+		req.Header.Set("session-token", "header")
+		assert.Equal(t, "header", wsSessionToken(req))
+	})
+
+	t.Run("should read the Authorization header", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		req.Header.Set("Authorization", "Bearer bearer")
+		assert.Equal(t, "bearer", wsSessionToken(req))
+	})
+
+	t.Run("should read the token following the session-token subprotocol", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/ws?token=query", nil)
+		req.Header.Set("Sec-WebSocket-Protocol", "session-token, subprotocol")
+		assert.Equal(t, "subprotocol", wsSessionToken(req))
+	})
+
+	t.Run("should ignore a session-token subprotocol without a token", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/ws?token=query", nil)
+		req.Header.Set("Sec-WebSocket-Protocol", "session-token")
+		assert.Equal(t, "query", wsSessionToken(req))
+	})
+
+	t.Run("should read the token query parameter", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/ws?token=query", nil)
+		assert.Equal(t, "query", wsSessionToken(req))
+	})
+
+	t.Run("should return nothing without a token", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		assert.Empty(t, wsSessionToken(req))
+	})
+}
+
+func TestCheckOrigin(t *testing.T) {
+	previous := allowedOrigins
+	allowedOrigins = []string{"https://app.example.com"}
+	t.Cleanup(func() { allowedOrigins = previous })
+
+	request := func(origin string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "http://api.example.com/ws", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		return req
+	}
+
+	t.Run("should accept requests without an origin", func(t *testing.T) {
+		assert.True(t, checkOrigin(request("")))
+	})
+
+	t.Run("should accept the same origin", func(t *testing.T) {
+		assert.True(t, checkOrigin(request("http://API.example.com")))
+	})
+
+	t.Run("should accept allowed origins", func(t *testing.T) {
+		assert.True(t, checkOrigin(request("https://app.example.com")))
+	})
+
+	t.Run("should reject other origins", func(t *testing.T) {
+		assert.False(t, checkOrigin(request("https://evil.example.com")))
+		assert.False(t, checkOrigin(request("://not a url")))
+	})
+
+	t.Run("should refuse to upgrade from a rejected origin", func(t *testing.T) {
+		err := ServeWs(nil, nil, httptest.NewRecorder(), request("https://evil.example.com"))
+
+		apiErr, ok := err.(*apis.ApiError)
+		if assert.True(t, ok, "expected *apis.ApiError, got %v", err) {
+			assert.Equal(t, http.StatusForbidden, apiErr.Code)
+		}
+	})
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+)
+
+// companionDefaults lists the per-user records every user needs, keyed by
+// collection, together with the values they start with.
+var companionDefaults = map[string]map[string]any{
+	"messages": {
+		"active_anon_chats": []string{},
+		"messages":          [][]byte{},
+	},
+	"attending_events": {
+		"attending_events": []string{},
+	},
+}
+
+// companionCollections keeps provisioning in a stable order.
+var companionCollections = []string{"messages", "attending_events"}
+
+// provisionUser creates every missing companion record of the user. Run it
+// with a transactional dao so a user is never left half-provisioned.
+func provisionUser(dao *daos.Dao, userId string) error {
+	for _, collection := range companionCollections {
+		_, err := dao.FindFirstRecordByData(collection, "user_id", userId)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		if _, err := createCompanionRecord(dao, collection, userId); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func createCompanionRecord(dao *daos.Dao, collectionName, userId string) (*models.Record, error) {
+	defaults, ok := companionDefaults[collectionName]
+	if !ok {
+		return nil, fmt.Errorf("%s is not a companion collection", collectionName)
+	}
+
+	collection, err := dao.FindCollectionByNameOrId(collectionName)
+	if err != nil {
+		return nil, err
+	}
+
+	record := models.NewRecord(collection)
+	record.Set("user_id", userId)
+	for field, value := range defaults {
+		record.Set(field, value)
+	}
+
+	if err := dao.SaveRecord(record); err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// FindCompanionRecord returns the user's record from one of the companion
+// collections, recreating it when it went missing for an existing user. This
+// is the fallback for users created but never provisioned, see
+// BindRegisterHooks.
+func FindCompanionRecord(dao *daos.Dao, collectionName, userId string) (*models.Record, error) {
+	record, err := dao.FindFirstRecordByData(collectionName, "user_id", userId)
+	if err == nil || !errors.Is(err, sql.ErrNoRows) {
+		return record, err
+	}
+
+	if _, err := dao.FindRecordById("users", userId); err != nil {
+		return nil, err
+	}
+
+	record, err = createCompanionRecord(dao, collectionName, userId)
+	if err != nil {
+		// a concurrent request may have healed the record first
+		if existing, findErr := dao.FindFirstRecordByData(collectionName, "user_id", userId); findErr == nil {
+			return existing, nil
+		}
+
+		return nil, err
+	}
+
+	return record, nil
+}
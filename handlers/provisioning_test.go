@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestFindCompanionRecord(t *testing.T) {
+	app := newTestApp(t)
+	user := newTestUser(t, app)
+
+	t.Run("should recreate a missing companion record", func(t *testing.T) {
+		record, err := FindCompanionRecord(app.Dao(), "messages", user.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, user.Id, record.GetString("user_id"))
+
+		found, err := FindCompanionRecord(app.Dao(), "messages", user.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, record.Id, found.Id)
+	})
+
+	t.Run("should not create records for unknown users", func(t *testing.T) {
+		_, err := FindCompanionRecord(app.Dao(), "messages", "unknown_user")
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+}
+
+func TestRepairUsers(t *testing.T) {
+	app := newTestApp(t)
+	user := newTestUser(t, app)
+	provisioned := newTestUser(t, app)
+	assert.NoError(t, provisionUser(app.Dao(), provisioned.Id))
+
+	repaired, err := RepairUsers(app)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{user.Id}, repaired)
+
+	missing, err := missingCompanions(app.Dao(), user.Id)
+	assert.NoError(t, err)
+	assert.Empty(t, missing)
+}
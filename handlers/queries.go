@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// inExp builds a bound `column IN (...)` expression. An empty set yields an
+// expression that matches nothing instead of the invalid `IN ()`.
+func inExp(column string, values []string) dbx.Expression {
+	args := make([]interface{}, len(values))
+	for i, value := range values {
+		args[i] = value
+	}
+
+	return dbx.In(column, args...)
+}
+
+func findEventsByCreators(dao *daos.Dao, userIds []string) ([]eventRecord, error) {
+	events := []eventRecord{}
+	if len(userIds) == 0 {
+		return events, nil
+	}
+
+	err := dao.DB().
+		Select("*").
+		From("events").
+		Where(inExp("user_id", userIds)).
+		All(&events)
+
+	return events, err
+}
+
+func findEventsByIds(dao *daos.Dao, eventIds []string) ([]eventRecord, error) {
+	events := []eventRecord{}
+	if len(eventIds) == 0 {
+		return events, nil
+	}
+
+	err := dao.DB().
+		Select("*").
+		From("events").
+		Where(inExp("id", eventIds)).
+		All(&events)
+
+	return events, err
+}
+
+func findEventsByVisibility(dao *daos.Dao, visibility string) ([]eventRecord, error) {
+	events := []eventRecord{}
+
+	err := dao.DB().
+		Select("*").
+		From("events").
+		Where(dbx.HashExp{"visibility": visibility}).
+		All(&events)
+
+	return events, err
+}
+
+func findEventsInvitedTo(dao *daos.Dao, userId string) ([]eventRecord, error) {
+	events := []eventRecord{}
+
+	err := dao.DB().
+		Select("*").
+		From("events").
+		Where(dbx.NewExp(
+			"EXISTS (SELECT 1 FROM "+jsonEach("[[events.invited]]")+" m WHERE m.value = {:value})",
+			dbx.Params{"value": userId},
+		)).
+		All(&events)
+
+	return events, err
+}
+
+func deleteChatFinderEntries(dao *daos.Dao, userIds []string) error {
+	if len(userIds) == 0 {
+		return nil
+	}
+
+	_, err := dao.DB().
+		Delete("chat_finder", inExp("user_id", userIds)).
+		Execute()
+
+	return err
+}
+
+// userSummary holds the public fields of a user shown in lists.
+type userSummary struct {
+	ID      string        `db:"id" json:"id"`
+	Name    string        `db:"name" json:"name,omitempty"`
+	Tag     string        `db:"tag" json:"tag"`
+	Avatar  string        `db:"avatar" json:"avatar,omitempty"`
+	Privacy types.JsonRaw `db:"privacy" json:"-"`
+}
+
+// hidePrivate clears the fields the user keeps from a viewer with the given
+// relationship and resolves the avatar URL.
+func (s *userSummary) hidePrivate(relationship string) {
+	privacy := parsePrivacy(s.Privacy)
+	if !isVisible(privacy, "name", relationship) {
+		s.Name = ""
+	}
+	if !isVisible(privacy, "avatar", relationship) {
+		s.Avatar = ""
+	}
+	s.Avatar = avatarURL(s.ID, s.Avatar)
+}
+
+// findUserSummaries returns the summaries of the users as seen by the viewer.
+func findUserSummaries(dao *daos.Dao, viewerId string, userIds []string) ([]userSummary, error) {
+	users := []userSummary{}
+	if len(userIds) == 0 {
+		return users, nil
+	}
+
+	err := dao.DB().
+		Select("id", "name", "tag", "avatar", "privacy").
+		From("users").
+		Where(inExp("id", userIds)).
+		All(&users)
+	if err != nil {
+		return nil, err
+	}
+
+	relationships, err := relationshipsWith(dao, viewerId, users)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range users {
+		users[i].hidePrivate(relationships[users[i].ID])
+	}
+
+	return users, nil
+}
+
+// friendsParam returns the user's friend IDs as a JSON array, to bind to the
+// {:friends} placeholder of visibleExp.
+func friendsParam(dao *daos.Dao, userId string) (string, error) {
+	friends, err := friendIds(dao, userId)
+	if err != nil {
+		return "", err
+	}
+
+	raw, err := json.Marshal(friends)
+
+	return string(raw), err
+}
+
+// jsonEach expands the JSON array stored in column as a table valued
+// function, treating empty or malformed values as an empty array.
+func jsonEach(column string) string {
+	return "json_each(CASE WHEN json_valid(" + column + ") THEN " + column + " ELSE '[]' END)"
+}
+
+// coMembers returns the other members of every row of table whose JSON array
+// column contains the user, once per shared row.
+func coMembers(dao *daos.Dao, table, column string, filter dbx.Expression, userId string) ([]string, error) {
+	query := dao.DB().
+		Select("m.value").
+		From(table+" t", jsonEach("t."+column)+" m").
+		Where(dbx.NewExp(
+			"EXISTS (SELECT 1 FROM "+jsonEach("t."+column)+" self WHERE self.value = {:userId})",
+			dbx.Params{"userId": userId},
+		)).
+		AndWhere(dbx.Not(dbx.HashExp{"m.value": userId}))
+	if filter != nil {
+		query.AndWhere(filter)
+	}
+
+	var members []string
+	err := query.Column(&members)
+
+	return members, err
+}
+
+// findRecordsContaining returns the records of the collection whose JSON
+// array column contains the value.
+func findRecordsContaining(dao *daos.Dao, collection, column, value string) ([]*models.Record, error) {
+	return dao.FindRecordsByExpr(collection, dbx.NewExp(
+		"EXISTS (SELECT 1 FROM "+jsonEach("[["+collection+"."+column+"]]")+" m WHERE m.value = {:value})",
+		dbx.Params{"value": value},
+	))
+}
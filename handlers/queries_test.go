@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const hostileId = "x') OR 1=1 --"
+
+func TestFindEventsByCreators(t *testing.T) {
+	app := newTestApp(t)
+	insertTestEvent(t, app.Dao().DB(), "event_1", "user_1")
+	insertTestEvent(t, app.Dao().DB(), "event_2", "user_2")
+
+	t.Run("should return no events for an empty creator list", func(t *testing.T) {
+		events, err := findEventsByCreators(app.Dao(), nil)
+		assert.NoError(t, err)
+		assert.Empty(t, events)
+	})
+
+	t.Run("should return events of the given creators", func(t *testing.T) {
+		events, err := findEventsByCreators(app.Dao(), []string{"user_1"})
+		assert.NoError(t, err)
+		if assert.Len(t, events, 1) {
+			assert.Equal(t, "event_1", events[0].ID)
+		}
+	})
+
+	t.Run("should treat hostile IDs as plain values", func(t *testing.T) {
+		events, err := findEventsByCreators(app.Dao(), []string{hostileId})
+		assert.NoError(t, err)
+		assert.Empty(t, events)
+	})
+}
+
+func TestFindEventsByIds(t *testing.T) {
+	app := newTestApp(t)
+	insertTestEvent(t, app.Dao().DB(), "event_1", "user_1")
+
+	t.Run("should return no events for an empty ID list", func(t *testing.T) {
+		events, err := findEventsByIds(app.Dao(), []string{})
+		assert.NoError(t, err)
+		assert.Empty(t, events)
+	})
+
+	t.Run("should treat hostile IDs as plain values", func(t *testing.T) {
+		events, err := findEventsByIds(app.Dao(), []string{"event_1", hostileId})
+		assert.NoError(t, err)
+		assert.Len(t, events, 1)
+	})
+}
+
+func TestDeleteChatFinderEntries(t *testing.T) {
+	app := newTestApp(t)
+	db := app.Dao().DB()
+	for _, userId := range []string{"user_1", "user_2"} {
+		_, err := db.Insert("chat_finder", dbx.Params{"id": userId, "user_id": userId, "interests": "[]"}).Execute()
+		assert.NoError(t, err)
+	}
+
+	countEntries := func() int {
+		var count int
+		assert.NoError(t, db.Select("count(*)").From("chat_finder").Row(&count))
+		return count
+	}
+
+	t.Run("should do nothing for an empty user list", func(t *testing.T) {
+		assert.NoError(t, deleteChatFinderEntries(app.Dao(), nil))
+		assert.Equal(t, 2, countEntries())
+	})
+
+	t.Run("should not delete other entries for hostile IDs", func(t *testing.T) {
+		assert.NoError(t, deleteChatFinderEntries(app.Dao(), []string{hostileId}))
+		assert.Equal(t, 2, countEntries())
+	})
+
+	t.Run("should delete entries of the given users", func(t *testing.T) {
+		assert.NoError(t, deleteChatFinderEntries(app.Dao(), []string{"user_1"}))
+		assert.Equal(t, 1, countEntries())
+	})
+}
+
+func insertTestEvent(t *testing.T, db dbx.Builder, eventId, userId string) {
+	_, err := db.Insert("events", dbx.Params{
+		"id":         eventId,
+		"user_id":    userId,
+		"name":       eventId,
+		"attendants": "[]",
+	}).Execute()
+	assert.NoError(t, err)
+}
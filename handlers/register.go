@@ -6,71 +6,53 @@ import (
 	"github.com/Pallinder/go-randomdata"
 	"github.com/pocketbase/pocketbase/apis"
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
 	"github.com/pocketbase/pocketbase/models"
+	"log"
 	"math/big"
 	"net/http"
 	"strings"
 )
 
 func BindRegisterHooks(app core.App) {
-	app.OnRecordAfterCreateRequest("users").Add(func(e *core.RecordCreateEvent) error {
-		setNewTag(e.Record)
-		initializeInterests(e.Record)
-		if err := app.Dao().SaveRecord(e.Record); err != nil {
-			return err
-		}
-		friendsCollection, err := app.Dao().FindCollectionByNameOrId("friends")
-		if err != nil {
-			return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
-		}
-
-		record := models.NewRecord(friendsCollection)
-
-		record.Set("user_id", e.Record.Id)
-		record.Set("friend_list", []Friend{})
-		record.Set("pending_list", []Friend{})
-		record.Set("sent_invites", []Friend{})
-
-		if err := app.Dao().SaveRecord(record); err != nil {
-			return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
-		}
-
-		messagesCollection, err := app.Dao().FindCollectionByNameOrId("messages")
-		if err != nil {
-			return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
-		}
-
-		messagesRecord := models.NewRecord(messagesCollection)
-
-		messagesRecord.Set("user_id", e.Record.Id)
-		messagesRecord.Set("active_anon_chats", []string{})
-		messagesRecord.Set("messages", [][]byte{})
-
-		if err := app.Dao().SaveRecord(messagesRecord); err != nil {
+	app.OnRecordBeforeCreateRequest("users").Add(func(e *core.RecordCreateEvent) error {
+		if err := setNewTag(app.Dao(), e.Record); err != nil {
+			log.Println("Failed to generate a tag", err)
 			return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
 		}
-
-		attendingEventsCollection, err := app.Dao().FindCollectionByNameOrId("attending_events")
+		initializeInterests(e.Record)
+		return nil
+	})
+	// PocketBase commits the user before any after-create hook runs and has no
+	// hook inside that write, so provisioning can't share its transaction. A
+	// crash in between leaves a user without companion records:
+	// FindCompanionRecord recreates them on first use and repair-users fixes
+	// them in bulk, so that gap is accepted rather than guarded here.
+	app.OnRecordAfterCreateRequest("users").Add(func(e *core.RecordCreateEvent) error {
+		err := app.Dao().RunInTransaction(func(txDao *daos.Dao) error {
+			return provisionUser(txDao, e.Record.Id)
+		})
 		if err != nil {
-			return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
-		}
-
-		attendingEventsRecord := models.NewRecord(attendingEventsCollection)
+			log.Println("Failed to provision user, rolling back registration", err)
+			if err := app.Dao().DeleteRecord(e.Record); err != nil {
+				log.Println("Failed to delete unprovisioned user", err)
+			}
 
-		attendingEventsRecord.Set("user_id", e.Record.Id)
-		attendingEventsRecord.Set("attending_events", []string{})
-
-		if err := app.Dao().SaveRecord(attendingEventsRecord); err != nil {
 			return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
 		}
 
 		return nil
-
 	})
 }
 
-func setNewTag(r *models.Record) {
-	r.Set("tag", generateUniqueTag(generateSillyName()))
+func setNewTag(dao *daos.Dao, r *models.Record) error {
+	tag, err := generateUniqueTag(dao)
+	if err != nil {
+		return err
+	}
+
+	r.Set("tag", tag)
+	return nil
 }
 
 func initializeInterests(r *models.Record) {
@@ -94,12 +76,3 @@ func generateRandomString(length int) string {
 
 	return randomString.String()
 }
-
-func generateUniqueTag(name string) string {
-	baseTag := strings.ReplaceAll(strings.ToLower(name), " ", "_")
-
-	randomString := generateRandomString(5)
-	uniqueTag := fmt.Sprintf("%s.%s", baseTag, randomString)
-
-	return uniqueTag
-}
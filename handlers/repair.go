@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/spf13/cobra"
+	"log"
+)
+
+// RepairUsers back-fills the companion records of every user missing any of
+// them and returns the IDs of the repaired users.
+func RepairUsers(app core.App) ([]string, error) {
+	var userIds []string
+	err := app.Dao().DB().Select("id").From("users").Column(&userIds)
+	if err != nil {
+		return nil, err
+	}
+
+	var repaired []string
+	for _, userId := range userIds {
+		missing, err := missingCompanions(app.Dao(), userId)
+		if err != nil {
+			return repaired, err
+		}
+		if len(missing) == 0 {
+			continue
+		}
+
+		err = app.Dao().RunInTransaction(func(txDao *daos.Dao) error {
+			return provisionUser(txDao, userId)
+		})
+		if err != nil {
+			return repaired, fmt.Errorf("failed to repair user %s: %w", userId, err)
+		}
+
+		log.Printf("Repaired user %s, created %v", userId, missing)
+		repaired = append(repaired, userId)
+	}
+
+	return repaired, nil
+}
+
+func missingCompanions(dao *daos.Dao, userId string) ([]string, error) {
+	var missing []string
+	for _, collection := range companionCollections {
+		var count int
+		err := dao.DB().
+			Select("count(*)").
+			From(collection).
+			Where(dbx.HashExp{"user_id": userId}).
+			Row(&count)
+		if err != nil {
+			return nil, err
+		}
+
+		if count == 0 {
+			missing = append(missing, collection)
+		}
+	}
+
+	return missing, nil
+}
+
+func NewRepairUsersCommand(app core.App) *cobra.Command {
+	return &cobra.Command{
+		Use:   "repair-users",
+		Short: "Back-fills the messages and attending_events records missing for any user",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repaired, err := RepairUsers(app)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Repaired %d user(s).\n", len(repaired))
+			return nil
+		},
+	}
+}
+
+// TagRepair describes a duplicate tag replaced by RepairTags.
+type TagRepair struct {
+	UserId string `db:"id"`
+	OldTag string `db:"tag"`
+	NewTag string
+}
+
+// RepairTags gives a new tag to every user sharing its tag, ignoring case,
+// with an older user, then enforces unique tags. With dryRun it only reports
+// the users it would change.
+func RepairTags(app core.App, dryRun bool) ([]*TagRepair, error) {
+	var repairs []*TagRepair
+	err := app.Dao().DB().
+		NewQuery(`SELECT id, tag FROM (
+			SELECT id, tag, ROW_NUMBER() OVER (PARTITION BY LOWER(tag) ORDER BY created, id) AS position
+			FROM users WHERE tag != ''
+		) WHERE position > 1 ORDER BY tag, id`).
+		All(&repairs)
+	if err != nil || dryRun {
+		return repairs, err
+	}
+
+	err = app.Dao().RunInTransaction(func(txDao *daos.Dao) error {
+		for _, repair := range repairs {
+			tag, err := generateUniqueTag(txDao)
+			if err != nil {
+				return err
+			}
+
+			_, err = txDao.DB().
+				Update("users", dbx.Params{"tag": tag}, dbx.HashExp{"id": repair.UserId}).
+				Execute()
+			if err != nil {
+				return err
+			}
+			repair.NewTag = tag
+		}
+
+		return ensureUniqueTagIndex(txDao)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, repair := range repairs {
+		log.Printf("Repaired user %s, replaced tag %s with %s", repair.UserId, repair.OldTag, repair.NewTag)
+	}
+
+	return repairs, nil
+}
+
+func NewRepairTagsCommand(app core.App) *cobra.Command {
+	var dryRun bool
+
+	command := &cobra.Command{
+		Use:   "repair-tags",
+		Short: "Gives new tags to users sharing their tag with another user and enforces unique tags",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repairs, err := RepairTags(app, dryRun)
+			if err != nil {
+				return err
+			}
+
+			if dryRun {
+				for _, repair := range repairs {
+					fmt.Printf("%s %s\n", repair.UserId, repair.OldTag)
+				}
+				fmt.Printf("Found %d duplicate tag(s).\n", len(repairs))
+				return nil
+			}
+
+			fmt.Printf("Repaired %d duplicate tag(s).\n", len(repairs))
+			return nil
+		},
+	}
+	command.Flags().BoolVar(&dryRun, "dry-run", false, "only list the users with a duplicate tag")
+
+	return command
+}
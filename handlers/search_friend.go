@@ -4,24 +4,83 @@ import (
 	"database/sql"
 	"errors"
 	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase/apis"
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
+const (
+	RelationshipFriend  = "friend"
+	RelationshipPending = "pending"
+	RelationshipNone    = "none"
+
+	defaultSearchPageSize = 20
+	maxSearchPageSize     = 50
+	maxSearchQueryLength  = 64
+)
+
+type searchPage struct {
+	Items      []searchResult `json:"items"`
+	NextCursor string         `json:"next_cursor"`
+}
+
+type searchResult struct {
+	userSummary
+	Relationship string `json:"relationship"`
+}
+
 func BindSearchFriendsHooks(app core.App) {
+	app.OnBeforeServe().Add(SearchUsers(app))
 	app.OnBeforeServe().Add(SearchFriend(app))
 }
 
-func SearchFriend(app core.App) func(e *core.ServeEvent) error {
+func SearchUsers(app core.App) func(e *core.ServeEvent) error {
 	return func(e *core.ServeEvent) error {
-		e.Router.POST("/api/search/:user_tag", func(c echo.Context) error {
-			session := getSessionToken(c.Request())
-			_, sessionErr := UserIdFromSession(session)
-			if sessionErr != nil {
-				return sessionErr
+		apiRoutes(app, e).GET("/search", func(c echo.Context) error {
+			query := strings.TrimSpace(c.QueryParam("q"))
+			if query == "" || len(query) > maxSearchQueryLength {
+				return apis.NewBadRequestError("Search query must be between 1 and 64 characters.", "")
+			}
+
+			limit := defaultSearchPageSize
+			if rawLimit := c.QueryParam("limit"); rawLimit != "" {
+				parsed, err := strconv.Atoi(rawLimit)
+				if err != nil || parsed < 1 {
+					return apis.NewBadRequestError("Limit must be a positive number.", "")
+				}
+				limit = min(parsed, maxSearchPageSize)
+			}
+
+			// results are ranked by relevance, the cursor is the offset of the next page
+			offset := 0
+			if cursor := c.QueryParam("cursor"); cursor != "" {
+				parsed, err := strconv.Atoi(cursor)
+				if err != nil || parsed < 0 {
+					return apis.NewBadRequestError("Invalid cursor.", "")
+				}
+				offset = parsed
 			}
 
+			page, err := searchUsers(app.Dao(), CurrentUser(c).Id, query, offset, limit)
+			if err != nil {
+				return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+			}
+
+			return c.JSON(http.StatusOK, page)
+		})
+		return nil
+	}
+}
+
+// SearchFriend is kept for clients relying on the exact tag lookup, new
+// clients use SearchUsers.
+func SearchFriend(app core.App) func(e *core.ServeEvent) error {
+	return func(e *core.ServeEvent) error {
+		apiRoutes(app, e).POST("/search/:user_tag", func(c echo.Context) error {
 			userTag := c.PathParam("user_tag")
 			_, err := app.Dao().FindFirstRecordByData("users", "tag", userTag)
 			if err != nil {
@@ -37,3 +96,115 @@ func SearchFriend(app core.App) func(e *core.ServeEvent) error {
 		return nil
 	}
 }
+
+// searchUsers matches the query against tags and the names the user may see,
+// best matches first: exact tag, tag prefix, name word prefix, substring and
+// finally the query letters appearing in order (fuzzy). Blocked users are left
+// out.
+func searchUsers(dao *daos.Dao, userId, query string, offset, limit int) (*searchPage, error) {
+	blocked, err := blockedIds(dao, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	friends, err := friendsParam(dao, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	escaped := escapeLike(strings.ToLower(query))
+	var fuzzy strings.Builder
+	fuzzy.WriteString("%")
+	for _, r := range strings.ToLower(query) {
+		fuzzy.WriteString(escapeLike(string(r)))
+		fuzzy.WriteString("%")
+	}
+	params := dbx.Params{
+		"exact":     strings.ToLower(query),
+		"prefix":    escaped + "%",
+		"word":      "% " + escaped + "%",
+		"substring": "%" + escaped + "%",
+		"fuzzy":     fuzzy.String(),
+		"friends":   friends,
+	}
+
+	// hidden names are searched as empty, so they can't reveal a match
+	name := "(CASE WHEN " + visibleExp("users", "name") + " THEN LOWER(name) ELSE '' END)"
+	score := `(CASE
+		WHEN LOWER(tag) = {:exact} THEN 100
+		WHEN LOWER(tag) LIKE {:prefix} ESCAPE '\' THEN 80
+		WHEN ` + name + ` LIKE {:prefix} ESCAPE '\' OR ` + name + ` LIKE {:word} ESCAPE '\' THEN 60
+		WHEN LOWER(tag) LIKE {:substring} ESCAPE '\' OR ` + name + ` LIKE {:substring} ESCAPE '\' THEN 40
+		ELSE 10
+	END)`
+
+	users := []userSummary{}
+	err = dao.DB().
+		Select("id", "name", "tag", "avatar", "privacy").
+		From("users").
+		Where(dbx.NewExp(`(LOWER(tag) LIKE {:fuzzy} ESCAPE '\' OR `+name+` LIKE {:fuzzy} ESCAPE '\')`, params)).
+		AndWhere(dbx.Not(dbx.HashExp{"id": userId})).
+		AndWhere(dbx.Not(inExp("id", blocked))).
+		OrderBy(score+" DESC", "tag ASC", "id ASC").
+		Bind(params).
+		Offset(int64(offset)).
+		// one extra row tells whether there is a next page
+		Limit(int64(limit + 1)).
+		All(&users)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &searchPage{Items: []searchResult{}}
+	if len(users) > limit {
+		users = users[:limit]
+		page.NextCursor = strconv.Itoa(offset + limit)
+	}
+
+	relationships, err := relationshipsWith(dao, userId, users)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, user := range users {
+		user.hidePrivate(relationships[user.ID])
+		page.Items = append(page.Items, searchResult{userSummary: user, Relationship: relationships[user.ID]})
+	}
+
+	return page, nil
+}
+
+// relationshipsWith returns the relationship status of the user with each of
+// the given users.
+func relationshipsWith(dao *daos.Dao, userId string, users []userSummary) (map[string]string, error) {
+	relationships := map[string]string{}
+	if len(users) == 0 {
+		return relationships, nil
+	}
+
+	pairs := make([]string, 0, len(users))
+	for _, user := range users {
+		relationships[user.ID] = RelationshipNone
+		pairs = append(pairs, friendshipPair(userId, user.ID))
+	}
+
+	friendships, err := dao.FindRecordsByExpr("friendships", inExp("pair", pairs))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, friendship := range friendships {
+		switch friendship.GetString("status") {
+		case FriendshipAccepted:
+			relationships[friendshipCounterpart(friendship, userId)] = RelationshipFriend
+		case FriendshipPending:
+			relationships[friendshipCounterpart(friendship, userId)] = RelationshipPending
+		}
+	}
+
+	return relationships, nil
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSearchUsers(t *testing.T) {
+	app := newTestApp(t)
+	user := newTestUser(t, app)
+
+	withTag := func(tag, name string) *models.Record {
+		record := newTestUser(t, app)
+		record.Set("tag", tag)
+		record.Set("name", name)
+		assert.NoError(t, app.Dao().SaveRecord(record))
+		return record
+	}
+	exact := withTag("maria", "Maria Pop")
+	prefix := withTag("mariana_99", "Ana Ionescu")
+	byName := withTag("zx81", "Ioana Marin")
+	fuzzy := withTag("m_a_r_x", "Dan Pop")
+	blocked := withTag("mariaB", "Maria Blocked")
+	withTag("other", "Dan Dan")
+
+	assert.NoError(t, runFriendsTransaction(app, func(txDao *daos.Dao) error {
+		if err := sendInvite(txDao, user.Id, exact.Id); err != nil {
+			return err
+		}
+		if err := acceptInvite(txDao, exact.Id, user.Id); err != nil {
+			return err
+		}
+		if err := sendInvite(txDao, prefix.Id, user.Id); err != nil {
+			return err
+		}
+		return addBlock(txDao, blocked.Id, user.Id, BlockKindBlock)
+	}))
+
+	ids := func(page *searchPage) []string {
+		var ids []string
+		for _, item := range page.Items {
+			ids = append(ids, item.ID)
+		}
+		return ids
+	}
+
+	t.Run("should rank exact, prefix, name and fuzzy matches", func(t *testing.T) {
+		page, err := searchUsers(app.Dao(), user.Id, "Mar", 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{exact.Id, prefix.Id, byName.Id, fuzzy.Id}, ids(page))
+		assert.Empty(t, page.NextCursor)
+	})
+
+	t.Run("should report the relationship status", func(t *testing.T) {
+		page, err := searchUsers(app.Dao(), user.Id, "mar", 0, 10)
+		assert.NoError(t, err)
+		if assert.Len(t, page.Items, 4) {
+			assert.Equal(t, RelationshipFriend, page.Items[0].Relationship)
+			assert.Equal(t, RelationshipPending, page.Items[1].Relationship)
+			assert.Equal(t, RelationshipNone, page.Items[2].Relationship)
+		}
+	})
+
+	t.Run("should paginate results", func(t *testing.T) {
+		first, err := searchUsers(app.Dao(), user.Id, "mar", 0, 3)
+		assert.NoError(t, err)
+		assert.Equal(t, "3", first.NextCursor)
+
+		second, err := searchUsers(app.Dao(), user.Id, "mar", 3, 3)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{fuzzy.Id}, ids(second))
+	})
+
+	t.Run("should not match or return names hidden from the user", func(t *testing.T) {
+		hidden := withTag("zz_hidden", "Secret Marvin")
+		hidden.Set("privacy", map[string]string{"name": VisibilityFriends, "avatar": VisibilityNobody})
+		hidden.Set("avatar", "avatar.png")
+		assert.NoError(t, app.Dao().SaveRecord(hidden))
+
+		page, err := searchUsers(app.Dao(), user.Id, "Secret", 0, 10)
+		assert.NoError(t, err)
+		assert.Empty(t, page.Items)
+
+		page, err = searchUsers(app.Dao(), user.Id, "zz_hidden", 0, 10)
+		assert.NoError(t, err)
+		if assert.Len(t, page.Items, 1) {
+			assert.Empty(t, page.Items[0].Name)
+			assert.Empty(t, page.Items[0].Avatar)
+		}
+
+		exact.Set("privacy", map[string]string{"name": VisibilityFriends})
+		assert.NoError(t, app.Dao().SaveRecord(exact))
+
+		page, err = searchUsers(app.Dao(), user.Id, "Maria Pop", 0, 10)
+		assert.NoError(t, err)
+		if assert.Len(t, page.Items, 1) {
+			assert.Equal(t, exact.Id, page.Items[0].ID)
+			assert.Equal(t, "Maria Pop", page.Items[0].Name)
+		}
+	})
+
+	t.Run("should treat wildcards as plain characters", func(t *testing.T) {
+		page, err := searchUsers(app.Dao(), user.Id, "%", 0, 10)
+		assert.NoError(t, err)
+		assert.Empty(t, page.Items)
+	})
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tokens"
+	"github.com/pocketbase/pocketbase/tools/security"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+const (
+	tokenVersionField = "token_version"
+	tokenVersionClaim = "ver"
+)
+
+type sessionResponse struct {
+	Token string `json:"token"`
+}
+
+func BindSessionHooks(app core.App) {
+	// PocketBase issues tokens from every auth endpoint (password, OAuth2,
+	// auth-refresh) through this hook, swap them for versioned ones
+	app.OnRecordAuthRequest("users").Add(func(e *core.RecordAuthEvent) error {
+		token, err := NewSessionToken(app, e.Record)
+		if err != nil {
+			log.Println("Failed to issue session token", err)
+			return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+		}
+
+		e.Token = token
+		return nil
+	})
+	app.OnBeforeServe().Add(RefreshSession(app))
+	app.OnBeforeServe().Add(Logout(app))
+}
+
+func RefreshSession(app core.App) func(e *core.ServeEvent) error {
+	return func(e *core.ServeEvent) error {
+		apiRoutes(app, e).POST("/auth/refresh", func(c echo.Context) error {
+			userRecord := CurrentUser(c)
+
+			rotate, _ := strconv.ParseBool(c.QueryParam("rotate"))
+			if rotate {
+				if err := revokeSessions(app, userRecord); err != nil {
+					log.Println("Failed to revoke sessions", err)
+					return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+				}
+			}
+
+			token, err := NewSessionToken(app, userRecord)
+			if err != nil {
+				log.Println("Failed to issue session token", err)
+				return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+			}
+
+			return c.JSON(http.StatusOK, sessionResponse{Token: token})
+		})
+		return nil
+	}
+}
+
+func Logout(app core.App) func(e *core.ServeEvent) error {
+	return func(e *core.ServeEvent) error {
+		apiRoutes(app, e).POST("/auth/logout", func(c echo.Context) error {
+			if err := revokeSessions(app, CurrentUser(c)); err != nil {
+				log.Println("Failed to revoke sessions", err)
+				return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+			}
+
+			return c.NoContent(http.StatusNoContent)
+		})
+		return nil
+	}
+}
+
+// NewSessionToken issues a record auth token stamped with the user's current
+// token version.
+func NewSessionToken(app core.App, userRecord *models.Record) (string, error) {
+	return security.NewJWT(
+		jwt.MapClaims{
+			"id":              userRecord.Id,
+			"type":            tokens.TypeAuthRecord,
+			"collectionId":    userRecord.Collection().Id,
+			tokenVersionClaim: SessionVersion(userRecord),
+		},
+		userRecord.TokenKey()+app.Settings().RecordAuthToken.Secret,
+		app.Settings().RecordAuthToken.Duration,
+	)
+}
+
+// SessionVersion returns the token version a session must carry to be valid.
+func SessionVersion(userRecord *models.Record) int {
+	return userRecord.GetInt(tokenVersionField)
+}
+
+// revokeSessions invalidates every token issued to the user so far. Bumping the
+// version lets our endpoints report them as revoked, while refreshing the token
+// key also locks them out of the PocketBase API.
+func revokeSessions(app core.App, userRecord *models.Record) error {
+	userRecord.Set(tokenVersionField, SessionVersion(userRecord)+1)
+	if err := userRecord.RefreshTokenKey(); err != nil {
+		return err
+	}
+
+	return app.Dao().SaveRecord(userRecord)
+}
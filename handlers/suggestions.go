@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/bogdancanciu/frekathon-backend/interests"
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultSuggestionsCount = 10
+	maxSuggestionsCount     = 50
+
+	mutualFriendWeight   = 3
+	sharedEventWeight    = 2
+	sharedChatWeight     = 2
+	sharedInterestWeight = 1
+)
+
+type friendSuggestion struct {
+	userSummary
+	Reasons []string `json:"reasons"`
+
+	mutualFriends   int
+	sharedInterests []string
+	sharedEvents    int
+	sharedChats     int
+}
+
+func (s *friendSuggestion) score() int {
+	return s.mutualFriends*mutualFriendWeight +
+		s.sharedEvents*sharedEventWeight +
+		s.sharedChats*sharedChatWeight +
+		len(s.sharedInterests)*sharedInterestWeight
+}
+
+// reasons describes what the users have in common, naming shared interests
+// after their catalog entry. Values missing from the catalog are shown as is.
+func (s *friendSuggestion) reasons(catalog *interests.Catalog) []string {
+	var reasons []string
+	if s.mutualFriends > 0 {
+		reasons = append(reasons, plural(s.mutualFriends, "1 mutual friend", "%d mutual friends"))
+	}
+	if len(s.sharedInterests) > 0 {
+		var names []string
+		for _, slug := range s.sharedInterests[:min(len(s.sharedInterests), 2)] {
+			if entry, ok := catalog.Entry(slug); ok {
+				names = append(names, entry.Name)
+			} else {
+				names = append(names, slug)
+			}
+		}
+		reasons = append(reasons, "both into "+strings.Join(names, " and "))
+	}
+	if s.sharedEvents > 0 {
+		reasons = append(reasons, plural(s.sharedEvents, "went to the same event", "went to %d events together"))
+	}
+	if s.sharedChats > 0 {
+		reasons = append(reasons, plural(s.sharedChats, "chatted in an anonymous group", "chatted in %d anonymous groups"))
+	}
+
+	return reasons
+}
+
+func plural(count int, one, many string) string {
+	if count == 1 {
+		return one
+	}
+
+	return fmt.Sprintf(many, count)
+}
+
+func GetFriendSuggestions(app core.App) func(e *core.ServeEvent) error {
+	return func(e *core.ServeEvent) error {
+		apiRoutes(app, e).GET("/friends/suggestions", func(c echo.Context) error {
+			limit := defaultSuggestionsCount
+			if rawLimit := c.QueryParam("limit"); rawLimit != "" {
+				parsed, err := strconv.Atoi(rawLimit)
+				if err != nil || parsed < 1 {
+					return apis.NewBadRequestError("Limit must be a positive number.", "")
+				}
+				limit = min(parsed, maxSuggestionsCount)
+			}
+
+			suggestions, err := suggestFriends(app.Dao(), CurrentUser(c), limit)
+			if err != nil {
+				log.Println("Failed to suggest friends", err)
+				return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+			}
+
+			return c.JSON(http.StatusOK, map[string]interface{}{"items": suggestions})
+		})
+		return nil
+	}
+}
+
+// suggestFriends ranks the users the user has no friendship, invitation or
+// block with by mutual friends, shared interests, attended events and past
+// anonymous group chats.
+func suggestFriends(dao *daos.Dao, user *models.Record, limit int) ([]*friendSuggestion, error) {
+	excluded, err := suggestionExclusions(dao, user.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := map[string]*friendSuggestion{}
+	candidate := func(userId string) *friendSuggestion {
+		if excluded[userId] {
+			return nil
+		}
+		if _, ok := candidates[userId]; !ok {
+			candidates[userId] = &friendSuggestion{}
+		}
+		return candidates[userId]
+	}
+
+	mutuals, err := friendsOfFriends(dao, user.Id)
+	if err != nil {
+		return nil, err
+	}
+	for _, userId := range mutuals {
+		if s := candidate(userId); s != nil {
+			s.mutualFriends++
+		}
+	}
+
+	interests, err := sharedInterests(dao, user)
+	if err != nil {
+		return nil, err
+	}
+	for userId, shared := range interests {
+		if s := candidate(userId); s != nil {
+			s.sharedInterests = shared
+		}
+	}
+
+	attendants, err := coMembers(dao, "events", "attendants", nil, user.Id)
+	if err != nil {
+		return nil, err
+	}
+	for _, userId := range attendants {
+		if s := candidate(userId); s != nil {
+			s.sharedEvents++
+		}
+	}
+
+	chatMembers, err := coMembers(dao, "chats", "participants", dbx.HashExp{"t.type": "group"}, user.Id)
+	if err != nil {
+		return nil, err
+	}
+	for _, userId := range chatMembers {
+		if s := candidate(userId); s != nil {
+			s.sharedChats++
+		}
+	}
+
+	ids := make([]string, 0, len(candidates))
+	for userId := range candidates {
+		ids = append(ids, userId)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		left, right := candidates[ids[i]].score(), candidates[ids[j]].score()
+		if left != right {
+			return left > right
+		}
+		return ids[i] < ids[j]
+	})
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	users, err := findUserSummaries(dao, user.Id, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, summary := range users {
+		candidates[summary.ID].userSummary = summary
+	}
+
+	catalog, err := loadInterestCatalog(dao)
+	if err != nil {
+		return nil, err
+	}
+
+	suggestions := make([]*friendSuggestion, 0, len(ids))
+	for _, userId := range ids {
+		s := candidates[userId]
+		// relations can outlive the users they point at
+		if s.ID == "" {
+			continue
+		}
+		s.Reasons = s.reasons(catalog)
+		suggestions = append(suggestions, s)
+	}
+
+	return suggestions, nil
+}
+
+// suggestionExclusions returns the user, the users with a pending or accepted
+// friendship with them and the blocked users.
+func suggestionExclusions(dao *daos.Dao, userId string) (map[string]bool, error) {
+	friendships, err := dao.FindRecordsByExpr("friendships",
+		dbx.In("status", FriendshipPending, FriendshipAccepted),
+		dbx.Or(
+			dbx.HashExp{"requester": userId},
+			dbx.HashExp{"addressee": userId},
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	blocked, err := blockedIds(dao, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	excluded := map[string]bool{userId: true}
+	for _, friendship := range friendships {
+		excluded[friendshipCounterpart(friendship, userId)] = true
+	}
+	for _, id := range blocked {
+		excluded[id] = true
+	}
+
+	return excluded, nil
+}
+
+// friendsOfFriends returns the friends of the user's friends, once per mutual
+// friend.
+func friendsOfFriends(dao *daos.Dao, userId string) ([]string, error) {
+	friends, err := friendIds(dao, userId)
+	if err != nil || len(friends) == 0 {
+		return nil, err
+	}
+
+	friendships, err := dao.FindRecordsByExpr("friendships",
+		dbx.HashExp{"status": FriendshipAccepted},
+		dbx.Or(inExp("requester", friends), inExp("addressee", friends)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	isFriend := map[string]bool{}
+	for _, id := range friends {
+		isFriend[id] = true
+	}
+
+	var ids []string
+	for _, friendship := range friendships {
+		requester, addressee := friendship.GetString("requester"), friendship.GetString("addressee")
+		if isFriend[requester] {
+			ids = append(ids, addressee)
+		}
+		if isFriend[addressee] {
+			ids = append(ids, requester)
+		}
+	}
+
+	return ids, nil
+}
+
+// sharedInterests returns, for every other user with an interest in common
+// with the user, the sorted shared interests. Users hiding their interests
+// from the user are left out.
+func sharedInterests(dao *daos.Dao, user *models.Record) (map[string][]string, error) {
+	interests := userInterests(user)
+
+	shared := map[string][]string{}
+	if len(interests) == 0 {
+		return shared, nil
+	}
+
+	friends, err := friendsParam(dao, user.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		ID       string `db:"id"`
+		Interest string `db:"interest"`
+	}
+	err = dao.DB().
+		Select("u.id", "i.value AS interest").
+		From("users u", jsonEach("u.interests")+" i").
+		Where(inExp("i.value", interests)).
+		AndWhere(dbx.Not(dbx.HashExp{"u.id": user.Id})).
+		AndWhere(dbx.NewExp(visibleExp("u", "interests"), dbx.Params{"friends": friends})).
+		OrderBy("i.value ASC").
+		All(&rows)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		shared[row.ID] = append(shared[row.ID], row.Interest)
+	}
+
+	return shared, nil
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"github.com/bogdancanciu/frekathon-backend/interests"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSuggestFriends(t *testing.T) {
+	app := newTestApp(t)
+	user := newTestUser(t, app)
+	friend := newTestUser(t, app)
+	friendOfFriend := newTestUser(t, app)
+	climber := newTestUser(t, app)
+	privateClimber := newTestUser(t, app)
+	gamer := newTestUser(t, app)
+	attendee := newTestUser(t, app)
+	invited := newTestUser(t, app)
+
+	befriend := func(userId, friendId string) {
+		assert.NoError(t, runFriendsTransaction(app, func(txDao *daos.Dao) error {
+			if err := sendInvite(txDao, userId, friendId); err != nil {
+				return err
+			}
+			return acceptInvite(txDao, friendId, userId)
+		}))
+	}
+	befriend(user.Id, friend.Id)
+	befriend(friend.Id, friendOfFriend.Id)
+	assert.NoError(t, runFriendsTransaction(app, func(txDao *daos.Dao) error {
+		return sendInvite(txDao, user.Id, invited.Id)
+	}))
+
+	for _, record := range []*models.Record{user, climber, privateClimber, invited} {
+		record.Set("interests", []string{"climbing", "chess"})
+		assert.NoError(t, app.Dao().SaveRecord(record))
+	}
+	climber.Set("privacy", map[string]string{"interests": VisibilityEveryone, "name": VisibilityFriends})
+	assert.NoError(t, app.Dao().SaveRecord(climber))
+	user.Set("interests", []string{"climbing", "chess", "video_games"})
+	assert.NoError(t, app.Dao().SaveRecord(user))
+	gamer.Set("interests", []string{"video_games"})
+	gamer.Set("privacy", map[string]string{"interests": VisibilityEveryone})
+	assert.NoError(t, app.Dao().SaveRecord(gamer))
+
+	_, err := app.Dao().DB().Insert("events", dbx.Params{
+		"id":         "event_1",
+		"user_id":    attendee.Id,
+		"name":       "event_1",
+		"attendants": `["` + attendee.Id + `","` + user.Id + `"]`,
+	}).Execute()
+	assert.NoError(t, err)
+
+	suggestions, err := suggestFriends(app.Dao(), user, 10)
+	assert.NoError(t, err)
+
+	reasons := map[string][]string{}
+	for _, suggestion := range suggestions {
+		reasons[suggestion.ID] = suggestion.Reasons
+	}
+
+	t.Run("should rank users by what they have in common", func(t *testing.T) {
+		if assert.Len(t, suggestions, 4) {
+			assert.Equal(t, friendOfFriend.Id, suggestions[0].ID)
+		}
+		assert.Equal(t, []string{"1 mutual friend"}, reasons[friendOfFriend.Id])
+		assert.Equal(t, []string{"both into Chess and Climbing"}, reasons[climber.Id])
+		assert.Equal(t, []string{"went to the same event"}, reasons[attendee.Id])
+		assert.Equal(t, []string{"both into Video games"}, reasons[gamer.Id])
+	})
+
+	t.Run("should name interests missing from the catalog as stored", func(t *testing.T) {
+		suggestion := &friendSuggestion{sharedInterests: []string{"knitting"}}
+		assert.Equal(t, []string{"both into knitting"}, suggestion.reasons(interests.NewCatalog(nil)))
+	})
+
+	t.Run("should only use interests and names the user may see", func(t *testing.T) {
+		assert.NotContains(t, reasons, privateClimber.Id)
+		for _, suggestion := range suggestions {
+			if suggestion.ID == climber.Id {
+				assert.Empty(t, suggestion.Name)
+			}
+		}
+	})
+
+	t.Run("should leave out friends and invited users", func(t *testing.T) {
+		assert.NotContains(t, reasons, friend.Id)
+		assert.NotContains(t, reasons, invited.Id)
+	})
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxTagAttempts = 5
+
+	tagFormatEnv       = "TAG_FORMAT"
+	tagSuffixLengthEnv = "TAG_SUFFIX_LENGTH"
+
+	defaultTagFormat       = "{name}.{suffix}"
+	defaultTagSuffixLength = 5
+
+	tagIndexName = "idx_users_tag"
+	// uniqueTagIndex keeps tags unique regardless of case. Users without a tag
+	// yet are left out.
+	uniqueTagIndex = "CREATE UNIQUE INDEX `" + tagIndexName + "` ON `users` (LOWER(`tag`)) WHERE `tag` != ''"
+)
+
+// customTagPattern restricts the tags users claim themselves.
+var customTagPattern = regexp.MustCompile(`^[a-z0-9_.]{3,32}$`)
+
+var errTagExhausted = errors.New("failed to generate a free tag")
+
+// TagConfig describes generated tags. Format replaces {name} with a random
+// silly name and {suffix} with SuffixLength random characters.
+type TagConfig struct {
+	Format       string
+	SuffixLength int
+}
+
+var tagConfig = tagConfigFromEnv()
+
+func tagConfigFromEnv() TagConfig {
+	config := TagConfig{Format: defaultTagFormat, SuffixLength: defaultTagSuffixLength}
+
+	if format := os.Getenv(tagFormatEnv); format != "" {
+		if !strings.Contains(format, "{suffix}") {
+			log.Printf("%s has no {suffix}, generated tags will often collide", tagFormatEnv)
+		}
+		config.Format = format
+	}
+
+	if rawLength := os.Getenv(tagSuffixLengthEnv); rawLength != "" {
+		length, err := strconv.Atoi(rawLength)
+		if err != nil || length < 1 {
+			log.Printf("Ignoring invalid %s %q", tagSuffixLengthEnv, rawLength)
+		} else {
+			config.SuffixLength = length
+		}
+	}
+
+	return config
+}
+
+// generateTag formats a tag from the name, it may already be taken.
+func generateTag(config TagConfig, name string) string {
+	baseTag := strings.ReplaceAll(strings.ToLower(name), " ", "_")
+
+	return strings.NewReplacer(
+		"{name}", baseTag,
+		"{suffix}", generateRandomString(config.SuffixLength),
+	).Replace(config.Format)
+}
+
+// generateUniqueTag generates tags until one isn't used by any user.
+func generateUniqueTag(dao *daos.Dao) (string, error) {
+	for attempt := 0; attempt < maxTagAttempts; attempt++ {
+		tag := generateTag(tagConfig, generateSillyName())
+
+		taken, err := tagTaken(dao, tag, "")
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return tag, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w after %d attempts", errTagExhausted, maxTagAttempts)
+}
+
+// tagTaken reports whether another user than exceptUserId already uses the
+// tag, ignoring case so look-alike tags can't be claimed.
+func tagTaken(dao *daos.Dao, tag, exceptUserId string) (bool, error) {
+	var count int
+	err := dao.DB().
+		Select("COUNT(*)").
+		From("users").
+		Where(dbx.NewExp("LOWER(tag) = {:tag}", dbx.Params{"tag": strings.ToLower(tag)})).
+		AndWhere(dbx.Not(dbx.HashExp{"id": exceptUserId})).
+		Row(&count)
+
+	return count > 0, err
+}
+
+// isTagConflict reports whether err was raised by the unique tag index, when
+// the tag got claimed between the check and the save.
+func isTagConflict(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed: index '"+tagIndexName+"'")
+}
+
+// ensureUniqueTagIndex sets uniqueTagIndex on the users collection, replacing
+// any previous tag index. It fails while duplicate tags exist.
+func ensureUniqueTagIndex(dao *daos.Dao) error {
+	collection, err := dao.FindCollectionByNameOrId("users")
+	if err != nil {
+		return err
+	}
+
+	indexes := []string{}
+	for _, index := range collection.Indexes {
+		if !strings.Contains(index, "`"+tagIndexName+"`") {
+			indexes = append(indexes, index)
+		}
+	}
+	collection.Indexes = append(indexes, uniqueTagIndex)
+
+	if err := dao.SaveCollection(collection); err != nil {
+		return fmt.Errorf("failed to create the unique tag index: %w", err)
+	}
+
+	return nil
+}
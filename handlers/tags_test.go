@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"testing"
+)
+
+func TestGenerateTag(t *testing.T) {
+	t.Run("should follow the configured format and suffix length", func(t *testing.T) {
+		tag := generateTag(TagConfig{Format: "{name}-{suffix}", SuffixLength: 8}, "Silly Name")
+		assert.Regexp(t, regexp.MustCompile(`^silly_name-[a-zA-Z0-9]{8}$`), tag)
+	})
+
+	t.Run("should generate a tag nobody uses", func(t *testing.T) {
+		app := newTestApp(t)
+
+		tag, err := generateUniqueTag(app.Dao())
+		assert.NoError(t, err)
+
+		taken, err := tagTaken(app.Dao(), tag, "")
+		assert.NoError(t, err)
+		assert.False(t, taken)
+	})
+}
+
+func TestRepairTags(t *testing.T) {
+	app := newTestApp(t)
+	db := app.Dao().DB()
+
+	// databases migrated before unique tags can still hold duplicates
+	_, err := db.NewQuery("DROP INDEX idx_users_tag").Execute()
+	assert.NoError(t, err)
+
+	original := newTestUser(t, app)
+	duplicates := []string{newTestUser(t, app).Id, newTestUser(t, app).Id}
+	for userId, tag := range map[string]string{original.Id: "same", duplicates[0]: "same", duplicates[1]: "SAME"} {
+		_, err := db.Update("users", dbx.Params{"tag": tag}, dbx.HashExp{"id": userId}).Execute()
+		assert.NoError(t, err)
+	}
+
+	t.Run("should only report duplicates on a dry run", func(t *testing.T) {
+		repairs, err := RepairTags(app, true)
+		assert.NoError(t, err)
+		assert.Len(t, repairs, 2)
+
+		taken, err := tagTaken(app.Dao(), "same", original.Id)
+		assert.NoError(t, err)
+		assert.True(t, taken)
+	})
+
+	t.Run("should re-tag duplicates and enforce unique tags", func(t *testing.T) {
+		repairs, err := RepairTags(app, false)
+		assert.NoError(t, err)
+
+		var repaired []string
+		for _, repair := range repairs {
+			repaired = append(repaired, repair.UserId)
+			assert.NotEmpty(t, repair.NewTag)
+		}
+		assert.ElementsMatch(t, duplicates, repaired)
+
+		kept, err := app.Dao().FindRecordById("users", original.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, "same", kept.GetString("tag"))
+
+		_, err = db.Update("users", dbx.Params{"tag": "Same"}, dbx.HashExp{"id": duplicates[0]}).Execute()
+		assert.True(t, isTagConflict(err), "expected a tag conflict, got %v", err)
+	})
+}
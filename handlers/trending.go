@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"github.com/bogdancanciu/frekathon-backend/interests"
+	"github.com/labstack/echo/v5"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
+	"github.com/pocketbase/pocketbase/tools/cron"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	trendingJobId    = "trending_interests"
+	trendingSchedule = "*/10 * * * *"
+	trendingLimit    = 10
+
+	defaultTrendingWindow = "7d"
+)
+
+var trendingWindows = map[string]time.Duration{
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+}
+
+// trendingInterest sums up the activity around an interest. Users and Queued
+// are current totals, NewUsers and Matches only count the window. Growth
+// compares their sum with the previous window and is left out when that one
+// had no activity.
+type trendingInterest struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Emoji    string   `json:"emoji"`
+	Users    int      `json:"users"`
+	Queued   int      `json:"queued"`
+	NewUsers int      `json:"new_users"`
+	Matches  int      `json:"matches"`
+	Growth   *float64 `json:"growth,omitempty"`
+
+	increase int
+}
+
+type trendingReport struct {
+	Window      string             `json:"window"`
+	Computed    types.DateTime     `json:"computed"`
+	Popular     []trendingInterest `json:"popular"`
+	Trending    []trendingInterest `json:"trending"`
+	TopMatching []trendingInterest `json:"top_matching"`
+}
+
+// trendingCache holds the last reports, per window.
+type trendingCache struct {
+	mu      sync.RWMutex
+	reports map[string]*trendingReport
+}
+
+func (tc *trendingCache) get(window string) *trendingReport {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+
+	return tc.reports[window]
+}
+
+func (tc *trendingCache) refresh(dao *daos.Dao) error {
+	reports := map[string]*trendingReport{}
+	for window, length := range trendingWindows {
+		report, err := computeTrending(dao, window, length, time.Now())
+		if err != nil {
+			return err
+		}
+		reports[window] = report
+	}
+
+	tc.mu.Lock()
+	tc.reports = reports
+	tc.mu.Unlock()
+
+	return nil
+}
+
+func GetTrendingInterests(app core.App, cache *trendingCache) func(e *core.ServeEvent) error {
+	return func(e *core.ServeEvent) error {
+		apiRoutes(app, e).GET("/interests/trending", func(c echo.Context) error {
+			window := c.QueryParam("window")
+			if window == "" {
+				window = defaultTrendingWindow
+			}
+			if _, ok := trendingWindows[window]; !ok {
+				return apis.NewBadRequestError("Window must be one of 24h, 7d or 30d.", "")
+			}
+
+			// the first refresh may still be running
+			report := cache.get(window)
+			if report == nil {
+				if err := cache.refresh(app.Dao()); err != nil {
+					log.Println("Failed to compute trending interests", err)
+					return apis.NewApiError(http.StatusInternalServerError, "Server error", "")
+				}
+				report = cache.get(window)
+			}
+
+			return c.JSON(http.StatusOK, report)
+		})
+		return nil
+	}
+}
+
+// ScheduleTrendingInterests recomputes the trending interests right away and
+// then every ten minutes while serving.
+func ScheduleTrendingInterests(app core.App, cache *trendingCache) func(e *core.ServeEvent) error {
+	return func(e *core.ServeEvent) error {
+		refresh := func() {
+			if err := cache.refresh(app.Dao()); err != nil {
+				log.Println("Failed to compute trending interests", err)
+			}
+		}
+
+		scheduler := cron.New()
+		scheduler.MustAdd(trendingJobId, trendingSchedule, refresh)
+		scheduler.Start()
+
+		app.OnTerminate().Add(func(e *core.TerminateEvent) error {
+			scheduler.Stop()
+			return nil
+		})
+
+		go refresh()
+
+		return nil
+	}
+}
+
+// computeTrending aggregates user interests, the chat finder queue and the
+// common interests of group chats for the window ending now.
+func computeTrending(dao *daos.Dao, window string, length time.Duration, now time.Time) (*trendingReport, error) {
+	catalog, err := loadInterestCatalog(dao)
+	if err != nil {
+		return nil, err
+	}
+
+	start, previousStart := now.Add(-length), now.Add(-2*length)
+
+	items := map[string]*trendingInterest{}
+	item := func(slug string) *trendingInterest {
+		if items[slug] == nil {
+			items[slug] = newTrendingInterest(catalog, slug)
+		}
+		return items[slug]
+	}
+
+	current := []struct {
+		table string
+		total func(*trendingInterest) *int
+	}{
+		{"users", func(ti *trendingInterest) *int { return &ti.Users }},
+		{"chat_finder", func(ti *trendingInterest) *int { return &ti.Queued }},
+	}
+	for _, count := range current {
+		totals, err := countInterests(dao, count.table, "interests", nil)
+		if err != nil {
+			return nil, err
+		}
+		for slug, total := range totals {
+			*count.total(item(slug)) = total
+		}
+	}
+
+	windowed := []struct {
+		table  string
+		column string
+		filter dbx.Expression
+		total  func(*trendingInterest) *int
+	}{
+		{"users", "interests", nil, func(ti *trendingInterest) *int { return &ti.NewUsers }},
+		{"chats", "common_interests", dbx.HashExp{"t.type": "group"}, func(ti *trendingInterest) *int { return &ti.Matches }},
+	}
+	previous := map[string]int{}
+	for _, count := range windowed {
+		totals, err := countInterests(dao, count.table, count.column, dbx.And(count.filter, createdBetween(start, now)))
+		if err != nil {
+			return nil, err
+		}
+		for slug, total := range totals {
+			*count.total(item(slug)) = total
+		}
+
+		totals, err = countInterests(dao, count.table, count.column, dbx.And(count.filter, createdBetween(previousStart, start)))
+		if err != nil {
+			return nil, err
+		}
+		for slug, total := range totals {
+			item(slug)
+			previous[slug] += total
+		}
+	}
+
+	report := &trendingReport{Window: window}
+	report.Computed, _ = types.ParseDateTime(now)
+
+	var all []trendingInterest
+	for slug, ti := range items {
+		if _, ok := catalog.Entry(slug); !ok {
+			continue
+		}
+
+		activity := ti.NewUsers + ti.Matches
+		ti.increase = activity - previous[slug]
+		if previous[slug] > 0 {
+			growth := float64(ti.increase) / float64(previous[slug])
+			ti.Growth = &growth
+		}
+		all = append(all, *ti)
+	}
+
+	report.Popular = topInterests(all, func(ti trendingInterest) int { return ti.Users })
+	report.Trending = topInterests(all, func(ti trendingInterest) int { return ti.increase })
+	report.TopMatching = topInterests(all, func(ti trendingInterest) int { return ti.Matches })
+
+	return report, nil
+}
+
+func newTrendingInterest(catalog *interests.Catalog, slug string) *trendingInterest {
+	entry, _ := catalog.Entry(slug)
+	return &trendingInterest{ID: slug, Name: entry.Name, Emoji: entry.Emoji}
+}
+
+// topInterests returns the interests with the highest positive score, up to
+// trendingLimit of them.
+func topInterests(all []trendingInterest, score func(trendingInterest) int) []trendingInterest {
+	top := []trendingInterest{}
+	for _, ti := range all {
+		if score(ti) > 0 {
+			top = append(top, ti)
+		}
+	}
+
+	sort.Slice(top, func(i, j int) bool {
+		if score(top[i]) != score(top[j]) {
+			return score(top[i]) > score(top[j])
+		}
+		return top[i].ID < top[j].ID
+	})
+
+	return top[:min(len(top), trendingLimit)]
+}
+
+// countInterests counts the rows of table whose JSON array column holds each
+// interest.
+func countInterests(dao *daos.Dao, table, column string, where dbx.Expression) (map[string]int, error) {
+	var rows []struct {
+		Interest string `db:"interest"`
+		Total    int    `db:"total"`
+	}
+	err := dao.DB().
+		Select("i.value AS interest", "COUNT(DISTINCT t.id) AS total").
+		From(table+" t", jsonEach("t."+column)+" i").
+		Where(where).
+		GroupBy("i.value").
+		All(&rows)
+	if err != nil {
+		return nil, err
+	}
+
+	totals := map[string]int{}
+	for _, row := range rows {
+		totals[row.Interest] = row.Total
+	}
+
+	return totals, nil
+}
+
+func createdBetween(start, end time.Time) dbx.Expression {
+	return dbx.NewExp("t.created >= {:start} AND t.created < {:end}", dbx.Params{
+		"start": start.UTC().Format(types.DefaultDateLayout),
+		"end":   end.UTC().Format(types.DefaultDateLayout),
+	})
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestComputeTrending(t *testing.T) {
+	app := newTestApp(t)
+	db := app.Dao().DB()
+	now := time.Now()
+
+	for _, interests := range [][]string{{"chess"}, {"chess", "hiking"}, {"hiking"}, {"hiking"}} {
+		user := newTestUser(t, app)
+		user.Set("interests", interests)
+		assert.NoError(t, app.Dao().SaveRecord(user))
+	}
+
+	chats, err := app.Dao().FindCollectionByNameOrId("chats")
+	assert.NoError(t, err)
+	for _, created := range []time.Time{now.Add(-time.Hour), now.Add(-2 * time.Hour), now.Add(-30 * time.Hour)} {
+		chat := models.NewRecord(chats)
+		chat.Set("type", "group")
+		chat.Set("common_interests", []string{"chess"})
+		assert.NoError(t, app.Dao().SaveRecord(chat))
+
+		_, err := db.Update("chats", dbx.Params{"created": created.UTC().Format(types.DefaultDateLayout)}, dbx.HashExp{"id": chat.Id}).Execute()
+		assert.NoError(t, err)
+	}
+
+	report, err := computeTrending(app.Dao(), "24h", 24*time.Hour, time.Now())
+	assert.NoError(t, err)
+
+	t.Run("should rank interests by users", func(t *testing.T) {
+		if assert.Len(t, report.Popular, 2) {
+			assert.Equal(t, "hiking", report.Popular[0].ID)
+			assert.Equal(t, "Hiking", report.Popular[0].Name)
+			assert.Equal(t, 3, report.Popular[0].Users)
+			assert.Equal(t, 2, report.Popular[1].Users)
+		}
+	})
+
+	t.Run("should count matches in the window only", func(t *testing.T) {
+		if assert.Len(t, report.TopMatching, 1) {
+			assert.Equal(t, "chess", report.TopMatching[0].ID)
+			assert.Equal(t, 2, report.TopMatching[0].Matches)
+		}
+	})
+
+	t.Run("should compare activity with the previous window", func(t *testing.T) {
+		if assert.NotEmpty(t, report.Trending) {
+			// 2 new users and 2 matches against a single match
+			assert.Equal(t, "chess", report.Trending[0].ID)
+			if assert.NotNil(t, report.Trending[0].Growth) {
+				assert.Equal(t, 3.0, *report.Trending[0].Growth)
+			}
+		}
+	})
+}
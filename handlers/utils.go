@@ -1,40 +1,81 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/pocketbase/pocketbase/apis"
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/daos"
 	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tokens"
+	"github.com/pocketbase/pocketbase/tools/security"
 	"io"
 	"net/http"
 	"strings"
 	"time"
 )
 
+// usersCollectionId is the fixed ID the users collection is created with.
+const usersCollectionId = "_pb_users_auth_"
+
 var (
 	expectedTokenParts = 3
 	errMalformedToken  = "Malformed session token."
 	errExpiredToken    = "Session token is expired."
+	errForgedToken     = "Session token signature is invalid."
+	errRevokedToken    = "Session token is no longer valid."
+)
+
+// Sentinel errors attached as raw data to the session *apis.ApiError values,
+// so callers can tell the failure reasons apart with IsSessionError.
+var (
+	ErrMalformedToken = errors.New(errMalformedToken)
+	ErrExpiredToken   = errors.New(errExpiredToken)
+	ErrForgedToken    = errors.New(errForgedToken)
+	ErrRevokedToken   = errors.New(errRevokedToken)
 )
 
-func getSessionToken(r *http.Request) string {
-	return r.Header.Get("session-token")
+func GetSessionToken(r *http.Request) string {
+	if sessionToken := r.Header.Get("session-token"); sessionToken != "" {
+		return sessionToken
+	}
+
+	// PocketBase clients send the same token through the standard header,
+	// optionally prefixed with the bearer scheme
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+func newSessionError(reason error) *apis.ApiError {
+	return apis.NewUnauthorizedError(reason.Error(), reason)
+}
+
+// IsSessionError reports whether err was produced by the session validation
+// for the given reason (one of the Err*Token sentinels).
+func IsSessionError(err *apis.ApiError, reason error) bool {
+	if err == nil {
+		return false
+	}
+	rawErr, ok := err.RawData().(error)
+
+	return ok && errors.Is(rawErr, reason)
 }
 
 func tokenPayload(sessionToken string) (map[string]interface{}, *apis.ApiError) {
 	tokenParts := strings.Split(sessionToken, ".")
 	if len(tokenParts) != expectedTokenParts {
-		return nil, apis.NewUnauthorizedError(errMalformedToken, "")
+		return nil, newSessionError(ErrMalformedToken)
 	}
 	payload, err := base64.RawURLEncoding.DecodeString(tokenParts[1])
 	if err != nil {
-		return nil, apis.NewUnauthorizedError(errMalformedToken, "")
+		return nil, newSessionError(ErrMalformedToken)
 	}
 
 	var claims map[string]interface{}
 	if err := json.Unmarshal(payload, &claims); err != nil {
-		return nil, apis.NewUnauthorizedError(errMalformedToken, "")
+		return nil, newSessionError(ErrMalformedToken)
 	}
 
 	return claims, nil
@@ -46,38 +87,120 @@ func validateSessionToken(sessionToken string) *apis.ApiError {
 		return err
 	}
 
+	exp, ok := payload["exp"].(float64)
+	if !ok {
+		return newSessionError(ErrMalformedToken)
+	}
+
 	currentTime := time.Now()
-	exp := payload["exp"].(float64)
 	tokenExpiryDate := time.Unix(int64(exp), 0)
 
 	if currentTime.After(tokenExpiryDate) {
-		return apis.NewUnauthorizedError(errExpiredToken, "")
+		return newSessionError(ErrExpiredToken)
 	}
 
 	return nil
 }
 
-func UserIdFromSession(sessionToken string) (string, *apis.ApiError) {
-	err := validateSessionToken(sessionToken)
+// Session describes a verified session token.
+type Session struct {
+	User      *models.Record
+	Version   int
+	ExpiresAt time.Time
+}
+
+// SessionFromToken verifies the session token against the PocketBase record auth
+// secret and the user's token version.
+func SessionFromToken(app core.App, sessionToken string) (*Session, *apis.ApiError) {
+	if err := validateSessionToken(sessionToken); err != nil {
+		return nil, err
+	}
+
+	payload, apiErr := tokenPayload(sessionToken)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	userId, ok := payload["id"].(string)
+	if !ok || userId == "" {
+		return nil, newSessionError(ErrMalformedToken)
+	}
+
+	if tokenType, _ := payload["type"].(string); tokenType != tokens.TypeAuthRecord {
+		return nil, newSessionError(ErrForgedToken)
+	}
+
+	usersCollection, err := app.Dao().FindCollectionByNameOrId("users")
 	if err != nil {
-		return "", err
+		return nil, apis.NewApiError(http.StatusInternalServerError, "Server error", "")
 	}
 
-	payload, err := tokenPayload(sessionToken)
+	if collectionId, _ := payload["collectionId"].(string); collectionId != usersCollection.Id {
+		return nil, newSessionError(ErrForgedToken)
+	}
+
+	userRecord, err := app.Dao().FindRecordById(usersCollection.Id, userId)
 	if err != nil {
-		return "", err
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, newSessionError(ErrRevokedToken)
+		}
+
+		return nil, apis.NewApiError(http.StatusInternalServerError, "Server error", "")
 	}
-	userId, ok := payload["id"].(string)
-	if !ok {
-		return "", apis.NewUnauthorizedError(errMalformedToken, "")
+
+	// tokens issued by PocketBase itself carry no version and count as version 0
+	tokenVersion, _ := payload[tokenVersionClaim].(float64)
+	revoked := int(tokenVersion) < SessionVersion(userRecord)
+
+	verificationKey := userRecord.TokenKey() + app.Settings().RecordAuthToken.Secret
+	if _, err := security.ParseJWT(sessionToken, verificationKey); err != nil {
+		switch {
+		case revoked:
+			return nil, newSessionError(ErrRevokedToken)
+		case errors.Is(err, jwt.ErrTokenExpired):
+			return nil, newSessionError(ErrExpiredToken)
+		default:
+			return nil, newSessionError(ErrForgedToken)
+		}
+	}
+
+	if revoked {
+		return nil, newSessionError(ErrRevokedToken)
+	}
+
+	exp, _ := payload["exp"].(float64)
+	session := &Session{
+		User:      userRecord,
+		Version:   int(tokenVersion),
+		ExpiresAt: time.Unix(int64(exp), 0),
+	}
+
+	return session, nil
+}
+
+// UserFromSession verifies the session token and returns the users record it
+// was issued for.
+func UserFromSession(app core.App, sessionToken string) (*models.Record, *apis.ApiError) {
+	session, err := SessionFromToken(app, sessionToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return session.User, nil
+}
+
+func UserIdFromSession(app core.App, sessionToken string) (string, *apis.ApiError) {
+	userRecord, err := UserFromSession(app, sessionToken)
+	if err != nil {
+		return "", err
 	}
 
-	return userId, nil
+	return userRecord.Id, nil
 }
 
-func createChat(app core.App, participants []string, chatType, description string) (string, *apis.ApiError) {
+func createChat(dao *daos.Dao, participants []string, chatType, description string) (string, error) {
 	var chatId string
-	chatsCollection, err := app.Dao().FindCollectionByNameOrId("chats")
+	chatsCollection, err := dao.FindCollectionByNameOrId("chats")
 	if err != nil {
 		return chatId, apis.NewApiError(http.StatusInternalServerError, "Server error", "")
 	}
@@ -88,7 +211,7 @@ func createChat(app core.App, participants []string, chatType, description strin
 	record.Set("type", chatType)
 	record.Set("description", description)
 
-	if err := app.Dao().SaveRecord(record); err != nil {
+	if err := dao.SaveRecord(record); err != nil {
 		return chatId, apis.NewApiError(http.StatusInternalServerError, "Server error", "")
 	}
 
@@ -96,6 +219,16 @@ func createChat(app core.App, participants []string, chatType, description strin
 	return chatId, nil
 }
 
+// avatarURL returns the path the avatar file is served from, or an empty
+// string when the user has no avatar.
+func avatarURL(userId, avatar string) string {
+	if avatar == "" {
+		return ""
+	}
+
+	return "/api/files/" + usersCollectionId + "/" + userId + "/" + avatar
+}
+
 func readBody(req *http.Request) ([]byte, error) {
 	bodyReader := req.Body
 	defer bodyReader.Close()
@@ -1,7 +1,15 @@
 package handlers
 
 import (
+	"fmt"
+	_ "github.com/bogdancanciu/frekathon-backend/migrations"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tokens"
+	"github.com/pocketbase/pocketbase/tools/migrate"
 	"github.com/pocketbase/pocketbase/tools/security"
 	"github.com/stretchr/testify/assert"
 	"testing"
@@ -42,22 +50,128 @@ func TestValidateSessionToken(t *testing.T) {
 }
 
 func TestUserIdFromSession(t *testing.T) {
+	app := newTestApp(t)
+	user := newTestUser(t, app)
+	secret := app.Settings().RecordAuthToken.Secret
+
 	t.Run("should return malformed token error when token doesn't embed an ID", func(t *testing.T) {
 		token := testJWT(t, jwt.MapClaims{}, 10)
 
-		_, err := UserIdFromSession(token)
+		_, err := UserIdFromSession(app, token)
 		assert.Error(t, err)
-		assert.Equal(t, errMalformedToken, err.Message)
+		assert.True(t, IsSessionError(err, ErrMalformedToken))
 	})
 
-	t.Run("should return malformed token error when token doesn't embed an ID", func(t *testing.T) {
-		claims := jwt.MapClaims{"id": "test_id"}
-		token := testJWT(t, claims, 10)
+	t.Run("should return user ID from a signed token", func(t *testing.T) {
+		token, err := tokens.NewRecordAuthToken(app, user)
+		assert.NoError(t, err)
 
-		userId, err := UserIdFromSession(token)
-		assert.Nil(t, err)
-		assert.Equal(t, claims["id"], userId)
+		userId, sessionErr := UserIdFromSession(app, token)
+		assert.Nil(t, sessionErr)
+		assert.Equal(t, user.Id, userId)
+	})
+
+	t.Run("should return forged token error when signature doesn't match", func(t *testing.T) {
+		token := testSignedJWT(t, user, "not_the_secret", 10)
+
+		_, err := UserIdFromSession(app, token)
+		assert.Error(t, err)
+		assert.True(t, IsSessionError(err, ErrForgedToken))
+	})
+
+	t.Run("should return forged token error when token type is not auth record", func(t *testing.T) {
+		token, err := security.NewJWT(jwt.MapClaims{
+			"id":           user.Id,
+			"type":         tokens.TypeAdmin,
+			"collectionId": user.Collection().Id,
+		}, user.TokenKey()+secret, 10)
+		assert.NoError(t, err)
+
+		_, sessionErr := UserIdFromSession(app, token)
+		assert.Error(t, sessionErr)
+		assert.True(t, IsSessionError(sessionErr, ErrForgedToken))
 	})
+
+	t.Run("should return expired token error", func(t *testing.T) {
+		token := testSignedJWT(t, user, secret, 0)
+
+		_, err := UserIdFromSession(app, token)
+		assert.Error(t, err)
+		assert.True(t, IsSessionError(err, ErrExpiredToken))
+	})
+
+	t.Run("should return revoked token error when user no longer exists", func(t *testing.T) {
+		deletedUser := newTestUser(t, app)
+		token, err := tokens.NewRecordAuthToken(app, deletedUser)
+		assert.NoError(t, err)
+		assert.NoError(t, app.Dao().DeleteRecord(deletedUser))
+
+		_, sessionErr := UserIdFromSession(app, token)
+		assert.Error(t, sessionErr)
+		assert.True(t, IsSessionError(sessionErr, ErrRevokedToken))
+	})
+
+	t.Run("should return revoked token error after sessions were revoked", func(t *testing.T) {
+		revokedUser := newTestUser(t, app)
+		token, err := NewSessionToken(app, revokedUser)
+		assert.NoError(t, err)
+		assert.NoError(t, revokeSessions(app, revokedUser))
+
+		_, sessionErr := UserIdFromSession(app, token)
+		assert.Error(t, sessionErr)
+		assert.True(t, IsSessionError(sessionErr, ErrRevokedToken))
+
+		token, err = NewSessionToken(app, revokedUser)
+		assert.NoError(t, err)
+
+		userId, sessionErr := UserIdFromSession(app, token)
+		assert.Nil(t, sessionErr)
+		assert.Equal(t, revokedUser.Id, userId)
+	})
+}
+
+func newTestApp(t *testing.T) *tests.TestApp {
+	app, err := tests.NewTestApp("../test_pb_data")
+	assert.NoError(t, err)
+	t.Cleanup(app.Cleanup)
+
+	runner, err := migrate.NewRunner(app.DB(), migrations.AppMigrations)
+	assert.NoError(t, err)
+	_, err = runner.Up()
+	assert.NoError(t, err)
+
+	return app
+}
+
+func newTestUser(t *testing.T, app core.App) *models.Record {
+	usersCollection, err := app.Dao().FindCollectionByNameOrId("users")
+	assert.NoError(t, err)
+
+	user := models.NewRecord(usersCollection)
+	username := security.RandomStringWithAlphabet(10, "abcdefghijklmnopqrstuvwxyz")
+	assert.NoError(t, user.SetUsername(username))
+	assert.NoError(t, user.SetEmail(fmt.Sprintf("%s@example.com", username)))
+	user.Set("name", "Test User")
+	assert.NoError(t, user.SetPassword("1234567890"))
+	assert.NoError(t, user.RefreshTokenKey())
+	assert.NoError(t, app.Dao().SaveRecord(user))
+
+	return user
+}
+
+func testSignedJWT(t *testing.T, user *models.Record, secret string, tokenDuration int64) string {
+	token, err := security.NewJWT(
+		jwt.MapClaims{
+			"id":           user.Id,
+			"type":         tokens.TypeAuthRecord,
+			"collectionId": user.Collection().Id,
+		},
+		user.TokenKey()+secret,
+		tokenDuration,
+	)
+	assert.NoError(t, err)
+
+	return token
 }
 
 func testJWT(t *testing.T, claims jwt.MapClaims, tokenDuration int64) string {
@@ -0,0 +1,113 @@
+package interests
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Entry is an interest of the catalog. Users store the slug, the name and
+// synonyms only help recognizing what they typed. Sub-interests point at the
+// slug of their parent and share its category.
+type Entry struct {
+	Slug     string   `db:"slug" json:"id"`
+	Name     string   `db:"name" json:"name"`
+	Category string   `db:"category" json:"category"`
+	Parent   string   `db:"parent" json:"parent,omitempty"`
+	Emoji    string   `db:"emoji" json:"emoji"`
+	Synonyms []string `db:"-" json:"synonyms"`
+}
+
+// Catalog resolves free-text interests to catalog slugs.
+type Catalog struct {
+	entries []Entry
+	bySlug  map[string]int
+	lookup  map[string]string
+}
+
+func NewCatalog(entries []Entry) *Catalog {
+	catalog := &Catalog{entries: entries, bySlug: map[string]int{}, lookup: map[string]string{}}
+
+	for _, entry := range entries {
+		for _, alias := range append([]string{entry.Slug, entry.Name}, entry.Synonyms...) {
+			if key := Normalize(alias); key != "" {
+				catalog.lookup[key] = entry.Slug
+			}
+		}
+	}
+
+	sort.Slice(catalog.entries, func(i, j int) bool {
+		if catalog.entries[i].Category != catalog.entries[j].Category {
+			return catalog.entries[i].Category < catalog.entries[j].Category
+		}
+		return catalog.entries[i].Name < catalog.entries[j].Name
+	})
+	for i, entry := range catalog.entries {
+		catalog.bySlug[entry.Slug] = i
+	}
+
+	return catalog
+}
+
+// Entries returns the catalog sorted by category and name.
+func (c *Catalog) Entries() []Entry {
+	return c.entries
+}
+
+// Entry returns the catalog entry with the slug.
+func (c *Catalog) Entry(slug string) (Entry, bool) {
+	i, ok := c.bySlug[slug]
+	if !ok {
+		return Entry{}, false
+	}
+
+	return c.entries[i], true
+}
+
+// Resolve returns the slug of the entry matching the value by slug, name or
+// synonym, ignoring case, spacing and a plural "s".
+func (c *Catalog) Resolve(value string) (string, bool) {
+	key := Normalize(value)
+	if slug, ok := c.lookup[key]; ok {
+		return slug, true
+	}
+
+	if singular := strings.TrimSuffix(key, "s"); singular != key {
+		if slug, ok := c.lookup[singular]; ok {
+			return slug, true
+		}
+	}
+
+	return "", false
+}
+
+// ResolveAll resolves every value, dropping duplicates. Values matching no
+// entry are returned separately.
+func (c *Catalog) ResolveAll(values []string) (slugs []string, unknown []string) {
+	slugs = []string{}
+	seen := map[string]bool{}
+
+	for _, value := range values {
+		slug, ok := c.Resolve(value)
+		if !ok {
+			unknown = append(unknown, value)
+			continue
+		}
+		if !seen[slug] {
+			seen[slug] = true
+			slugs = append(slugs, slug)
+		}
+	}
+
+	return slugs, unknown
+}
+
+// Normalize lowercases the value and collapses spaces, dashes and
+// underscores into single spaces.
+func Normalize(value string) string {
+	fields := strings.FieldsFunc(strings.ToLower(value), func(r rune) bool {
+		return unicode.IsSpace(r) || r == '-' || r == '_'
+	})
+
+	return strings.Join(fields, " ")
+}
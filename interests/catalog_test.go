@@ -0,0 +1,42 @@
+package interests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCatalog(t *testing.T) {
+	catalog := NewCatalog([]Entry{
+		{Slug: "hiking", Name: "Hiking", Category: "outdoors", Synonyms: []string{"trekking"}},
+		{Slug: "board_games", Name: "Board games", Category: "games"},
+		{Slug: "chess", Name: "Chess", Category: "games"},
+	})
+
+	t.Run("should sort entries by category and name", func(t *testing.T) {
+		var slugs []string
+		for _, entry := range catalog.Entries() {
+			slugs = append(slugs, entry.Slug)
+		}
+		assert.Equal(t, []string{"board_games", "chess", "hiking"}, slugs)
+	})
+
+	t.Run("should resolve slugs, names and synonyms loosely", func(t *testing.T) {
+		for _, value := range []string{"hiking", "  HIKING ", "Trekking"} {
+			slug, ok := catalog.Resolve(value)
+			assert.True(t, ok, value)
+			assert.Equal(t, "hiking", slug)
+		}
+
+		slug, ok := catalog.Resolve("Board-Game")
+		assert.False(t, ok)
+		slug, ok = catalog.Resolve("board_games")
+		assert.True(t, ok)
+		assert.Equal(t, "board_games", slug)
+	})
+
+	t.Run("should drop duplicates and report unknown values", func(t *testing.T) {
+		slugs, unknown := catalog.ResolveAll([]string{"Chess", "trekking", "chess", "knitting"})
+		assert.Equal(t, []string{"chess", "hiking"}, slugs)
+		assert.Equal(t, []string{"knitting"}, unknown)
+	})
+}
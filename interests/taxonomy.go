@@ -0,0 +1,55 @@
+package interests
+
+// maxTaxonomyDepth bounds the walk up the parents so a parent loop in the
+// catalog can't hang matching.
+const maxTaxonomyDepth = 8
+
+// Path returns the category of the interest followed by its ancestors and the
+// slug itself, from the most general to the most specific. Unknown slugs have
+// a path of their own.
+func (c *Catalog) Path(slug string) []string {
+	entry, ok := c.Entry(slug)
+	if !ok {
+		return []string{slug}
+	}
+
+	path := []string{entry.Slug}
+	for depth := 0; entry.Parent != "" && depth < maxTaxonomyDepth; depth++ {
+		parent, ok := c.Entry(entry.Parent)
+		if !ok {
+			break
+		}
+		path = append([]string{parent.Slug}, path...)
+		entry = parent
+	}
+
+	return append([]string{entry.Category}, path...)
+}
+
+// Common returns the most specific node of the taxonomy both interests
+// belong to, a shared ancestor or category, and how similar they are from 0
+// for unrelated interests to 1 for the same one. The deeper the shared
+// ancestor, the closer the score gets to 1.
+func (c *Catalog) Common(a, b string) (string, float64) {
+	if a == b {
+		return a, 1
+	}
+
+	pathA, pathB := c.Path(a), c.Path(b)
+
+	shared := 0
+	for shared < min(len(pathA), len(pathB)) && pathA[shared] == pathB[shared] {
+		shared++
+	}
+	if shared == 0 {
+		return "", 0
+	}
+
+	return pathA[shared-1], float64(2*shared) / float64(len(pathA)+len(pathB))
+}
+
+// Similarity is the score of Common.
+func (c *Catalog) Similarity(a, b string) float64 {
+	_, similarity := c.Common(a, b)
+	return similarity
+}
@@ -0,0 +1,41 @@
+package interests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTaxonomy(t *testing.T) {
+	catalog := NewCatalog([]Entry{
+		{Slug: "climbing", Category: "outdoors"},
+		{Slug: "bouldering", Category: "outdoors", Parent: "climbing"},
+		{Slug: "sport_climbing", Category: "outdoors", Parent: "climbing"},
+		{Slug: "hiking", Category: "outdoors"},
+		{Slug: "chess", Category: "games"},
+	})
+
+	t.Run("should walk from the category down to the interest", func(t *testing.T) {
+		assert.Equal(t, []string{"outdoors", "climbing", "bouldering"}, catalog.Path("bouldering"))
+		assert.Equal(t, []string{"knitting"}, catalog.Path("knitting"))
+	})
+
+	t.Run("should score closer ancestors higher", func(t *testing.T) {
+		common, similarity := catalog.Common("bouldering", "climbing")
+		assert.Equal(t, "climbing", common)
+		assert.InDelta(t, 0.8, similarity, 0.001)
+
+		common, similarity = catalog.Common("bouldering", "sport_climbing")
+		assert.Equal(t, "climbing", common)
+		assert.InDelta(t, 0.667, similarity, 0.001)
+
+		common, similarity = catalog.Common("bouldering", "hiking")
+		assert.Equal(t, "outdoors", common)
+		assert.InDelta(t, 0.4, similarity, 0.001)
+	})
+
+	t.Run("should not relate different categories", func(t *testing.T) {
+		assert.Equal(t, 1.0, catalog.Similarity("chess", "chess"))
+		assert.Zero(t, catalog.Similarity("chess", "climbing"))
+		assert.Zero(t, catalog.Similarity("knitting", "sewing"))
+	})
+}
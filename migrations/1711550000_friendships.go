@@ -0,0 +1,186 @@
+package migrations
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// legacyFriend mirrors the entries of the JSON arrays stored in the friends
+// collection before friendships were normalized.
+type legacyFriend struct {
+	ID     string `json:"id"`
+	ChatId string `json:"chat_id"`
+}
+
+// Moves friendship state from the friends collection JSON arrays into one
+// friendships row per pair. The friends collection is left untouched so the
+// migration can be rolled back.
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		chats, err := dao.FindCollectionByNameOrId("chats")
+		if err != nil {
+			return err
+		}
+
+		collection := &models.Collection{
+			Name:       "friendships",
+			Type:       models.CollectionTypeBase,
+			ListRule:   types.Pointer("requester = @request.auth.id || addressee = @request.auth.id"),
+			ViewRule:   types.Pointer("requester = @request.auth.id || addressee = @request.auth.id"),
+			CreateRule: nil,
+			UpdateRule: nil,
+			DeleteRule: nil,
+			Schema: schema.NewSchema(
+				&schema.SchemaField{
+					Name:     "requester",
+					Type:     schema.FieldTypeRelation,
+					Required: true,
+					Options: &schema.RelationOptions{
+						CollectionId:  "_pb_users_auth_",
+						CascadeDelete: true,
+						MaxSelect:     types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "addressee",
+					Type:     schema.FieldTypeRelation,
+					Required: true,
+					Options: &schema.RelationOptions{
+						CollectionId:  "_pb_users_auth_",
+						CascadeDelete: true,
+						MaxSelect:     types.Pointer(1),
+					},
+				},
+				&schema.SchemaField{
+					Name:     "pair",
+					Type:     schema.FieldTypeText,
+					Required: true,
+					Options:  &schema.TextOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "status",
+					Type:     schema.FieldTypeSelect,
+					Required: true,
+					Options: &schema.SelectOptions{
+						MaxSelect: 1,
+						Values:    []string{"pending", "accepted", "declined", "blocked"},
+					},
+				},
+				&schema.SchemaField{
+					Name: "chat_id",
+					Type: schema.FieldTypeRelation,
+					Options: &schema.RelationOptions{
+						CollectionId: chats.Id,
+						MaxSelect:    types.Pointer(1),
+					},
+				},
+			),
+			Indexes: types.JsonArray[string]{
+				"CREATE UNIQUE INDEX `idx_friendships_pair` ON `friendships` (`pair`)",
+				"CREATE INDEX `idx_friendships_requester` ON `friendships` (`requester`, `status`)",
+				"CREATE INDEX `idx_friendships_addressee` ON `friendships` (`addressee`, `status`)",
+			},
+		}
+
+		if err := dao.SaveCollection(collection); err != nil {
+			return err
+		}
+
+		return convertLegacyFriends(dao, collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("friendships")
+		if err != nil {
+			return err
+		}
+
+		return dao.DeleteCollection(collection)
+	})
+}
+
+func convertLegacyFriends(dao *daos.Dao, collection *models.Collection) error {
+	friendsRecords, err := dao.FindRecordsByExpr("friends")
+	if err != nil {
+		return err
+	}
+
+	var userIds []string
+	if err := dao.DB().Select("id").From("users").Column(&userIds); err != nil {
+		return err
+	}
+	users := map[string]bool{}
+	for _, id := range userIds {
+		users[id] = true
+	}
+
+	friendships := map[string]*models.Record{}
+	upsert := func(requester, addressee, status, chatId string) {
+		// entries pointing at deleted users have nothing left to convert
+		if !users[requester] || !users[addressee] || requester == addressee {
+			return
+		}
+
+		ids := []string{requester, addressee}
+		sort.Strings(ids)
+		pair := strings.Join(ids, ":")
+
+		existing, ok := friendships[pair]
+		if ok && (existing.GetString("status") == "accepted" || status != "accepted") {
+			return
+		}
+
+		record := models.NewRecord(collection)
+		record.Set("requester", requester)
+		record.Set("addressee", addressee)
+		record.Set("pair", pair)
+		record.Set("status", status)
+		record.Set("chat_id", chatId)
+		friendships[pair] = record
+	}
+
+	for _, friendsRecord := range friendsRecords {
+		userId := friendsRecord.GetString("user_id")
+
+		var friendList, pendingList, sentInvites []legacyFriend
+		for field, list := range map[string]*[]legacyFriend{
+			"friend_list":  &friendList,
+			"pending_list": &pendingList,
+			"sent_invites": &sentInvites,
+		} {
+			if raw, ok := friendsRecord.Get(field).(types.JsonRaw); ok && len(raw) > 0 {
+				if err := json.Unmarshal(raw, list); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, friend := range friendList {
+			upsert(userId, friend.ID, "accepted", friend.ChatId)
+		}
+		for _, invite := range sentInvites {
+			upsert(userId, invite.ID, "pending", "")
+		}
+		for _, pending := range pendingList {
+			upsert(pending.ID, userId, "pending", "")
+		}
+	}
+
+	for _, record := range friendships {
+		if err := dao.SaveRecord(record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
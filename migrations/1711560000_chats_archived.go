@@ -0,0 +1,43 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("chats")
+		if err != nil {
+			return err
+		}
+
+		if collection.Schema.GetFieldByName("archived") != nil {
+			return nil
+		}
+
+		collection.Schema.AddField(&schema.SchemaField{
+			Name: "archived",
+			Type: schema.FieldTypeBool,
+		})
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("chats")
+		if err != nil {
+			return err
+		}
+
+		if field := collection.Schema.GetFieldByName("archived"); field != nil {
+			collection.Schema.RemoveField(field.Id)
+		}
+
+		return dao.SaveCollection(collection)
+	})
+}
@@ -0,0 +1,59 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Adds the profile bio and the avatar thumbnail sizes served by the files API
+// (`?thumb=100x100`).
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+
+		if collection.Schema.GetFieldByName("bio") == nil {
+			collection.Schema.AddField(&schema.SchemaField{
+				Name: "bio",
+				Type: schema.FieldTypeText,
+				Options: &schema.TextOptions{
+					Max: types.Pointer(300),
+				},
+			})
+		}
+
+		if avatar := collection.Schema.GetFieldByName("avatar"); avatar != nil {
+			if options, ok := avatar.Options.(*schema.FileOptions); ok {
+				options.Thumbs = []string{"100x100", "400x400"}
+			}
+		}
+
+		return dao.SaveCollection(collection)
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+
+		if field := collection.Schema.GetFieldByName("bio"); field != nil {
+			collection.Schema.RemoveField(field.Id)
+		}
+
+		if avatar := collection.Schema.GetFieldByName("avatar"); avatar != nil {
+			if options, ok := avatar.Options.(*schema.FileOptions); ok {
+				options.Thumbs = nil
+			}
+		}
+
+		return dao.SaveCollection(collection)
+	})
+}
@@ -0,0 +1,66 @@
+package migrations
+
+import (
+	"fmt"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"strings"
+)
+
+const (
+	tagIndexName = "idx_users_tag"
+
+	// snapshotTagIndex is the case sensitive index of the collections snapshot.
+	snapshotTagIndex = "CREATE UNIQUE INDEX `" + tagIndexName + "` ON `users` (`tag`) WHERE `tag` != ''"
+	// uniqueTagIndex keeps tags unique regardless of case.
+	uniqueTagIndex = "CREATE UNIQUE INDEX `" + tagIndexName + "` ON `users` (LOWER(`tag`)) WHERE `tag` != ''"
+)
+
+// Makes tags unique regardless of case. Users only differing by the case of
+// their tag must be given new tags with the repair-tags command first.
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		duplicates, err := countDuplicateTags(dao)
+		if err != nil {
+			return err
+		}
+		if duplicates > 0 {
+			return fmt.Errorf("found %d users with a duplicate tag, run repair-tags before migrating", duplicates)
+		}
+
+		return setTagIndex(dao, uniqueTagIndex)
+	}, func(db dbx.Builder) error {
+		return setTagIndex(daos.New(db), snapshotTagIndex)
+	})
+}
+
+// countDuplicateTags returns how many users share their tag, ignoring case,
+// with an older user.
+func countDuplicateTags(dao *daos.Dao) (int, error) {
+	var count int
+	err := dao.DB().
+		NewQuery("SELECT COALESCE(SUM(total - 1), 0) FROM (SELECT COUNT(*) AS total FROM users WHERE tag != '' GROUP BY LOWER(tag))").
+		Row(&count)
+
+	return count, err
+}
+
+func setTagIndex(dao *daos.Dao, index string) error {
+	collection, err := dao.FindCollectionByNameOrId("users")
+	if err != nil {
+		return err
+	}
+
+	indexes := []string{}
+	for _, existing := range collection.Indexes {
+		if !strings.Contains(existing, "`"+tagIndexName+"`") {
+			indexes = append(indexes, existing)
+		}
+	}
+	collection.Indexes = append(indexes, index)
+
+	return dao.SaveCollection(collection)
+}
@@ -0,0 +1,244 @@
+package migrations
+
+import (
+	"encoding/json"
+	"github.com/bogdancanciu/frekathon-backend/interests"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"log"
+	"sort"
+)
+
+var interestsCatalog = []interests.Entry{
+	{Slug: "hiking", Name: "Hiking", Category: "outdoors", Emoji: "🥾", Synonyms: []string{"hike", "trekking", "trek"}},
+	{Slug: "climbing", Name: "Climbing", Category: "outdoors", Emoji: "🧗", Synonyms: []string{"climb", "bouldering", "rock climbing"}},
+	{Slug: "camping", Name: "Camping", Category: "outdoors", Emoji: "🏕️", Synonyms: []string{"camp"}},
+	{Slug: "cycling", Name: "Cycling", Category: "outdoors", Emoji: "🚴", Synonyms: []string{"biking", "bike", "bicycle"}},
+	{Slug: "running", Name: "Running", Category: "sports", Emoji: "🏃", Synonyms: []string{"run", "jogging", "marathon"}},
+	{Slug: "football", Name: "Football", Category: "sports", Emoji: "⚽", Synonyms: []string{"soccer"}},
+	{Slug: "basketball", Name: "Basketball", Category: "sports", Emoji: "🏀"},
+	{Slug: "tennis", Name: "Tennis", Category: "sports", Emoji: "🎾", Synonyms: []string{"padel"}},
+	{Slug: "swimming", Name: "Swimming", Category: "sports", Emoji: "🏊", Synonyms: []string{"swim"}},
+	{Slug: "gym", Name: "Gym", Category: "sports", Emoji: "🏋️", Synonyms: []string{"fitness", "workout", "weightlifting"}},
+	{Slug: "yoga", Name: "Yoga", Category: "wellness", Emoji: "🧘", Synonyms: []string{"pilates"}},
+	{Slug: "meditation", Name: "Meditation", Category: "wellness", Emoji: "🕯️", Synonyms: []string{"mindfulness"}},
+	{Slug: "painting", Name: "Painting", Category: "arts", Emoji: "🎨", Synonyms: []string{"drawing", "art"}},
+	{Slug: "photography", Name: "Photography", Category: "arts", Emoji: "📷", Synonyms: []string{"photo", "photos"}},
+	{Slug: "writing", Name: "Writing", Category: "arts", Emoji: "✍️", Synonyms: []string{"poetry", "blogging"}},
+	{Slug: "reading", Name: "Reading", Category: "arts", Emoji: "📚", Synonyms: []string{"books", "literature"}},
+	{Slug: "movies", Name: "Movies", Category: "arts", Emoji: "🎬", Synonyms: []string{"movie", "cinema", "films", "film"}},
+	{Slug: "theatre", Name: "Theatre", Category: "arts", Emoji: "🎭", Synonyms: []string{"theater", "acting"}},
+	{Slug: "concerts", Name: "Concerts", Category: "music", Emoji: "🎤", Synonyms: []string{"live music", "festivals"}},
+	{Slug: "guitar", Name: "Guitar", Category: "music", Emoji: "🎸"},
+	{Slug: "piano", Name: "Piano", Category: "music", Emoji: "🎹", Synonyms: []string{"keyboard"}},
+	{Slug: "singing", Name: "Singing", Category: "music", Emoji: "🎙️", Synonyms: []string{"karaoke", "choir"}},
+	{Slug: "dancing", Name: "Dancing", Category: "music", Emoji: "💃", Synonyms: []string{"dance"}},
+	{Slug: "programming", Name: "Programming", Category: "tech", Emoji: "💻", Synonyms: []string{"coding", "software", "code"}},
+	{Slug: "gadgets", Name: "Gadgets", Category: "tech", Emoji: "📱", Synonyms: []string{"tech", "technology"}},
+	{Slug: "video_games", Name: "Video games", Category: "games", Emoji: "🎮", Synonyms: []string{"gaming", "games", "esports"}},
+	{Slug: "board_games", Name: "Board games", Category: "games", Emoji: "🎲", Synonyms: []string{"tabletop", "card games"}},
+	{Slug: "chess", Name: "Chess", Category: "games", Emoji: "♟️"},
+	{Slug: "cooking", Name: "Cooking", Category: "food", Emoji: "🍳", Synonyms: []string{"cook", "baking"}},
+	{Slug: "coffee", Name: "Coffee", Category: "food", Emoji: "☕", Synonyms: []string{"cafes"}},
+	{Slug: "wine", Name: "Wine", Category: "food", Emoji: "🍷", Synonyms: []string{"wine tasting"}},
+	{Slug: "travel", Name: "Travel", Category: "social", Emoji: "✈️", Synonyms: []string{"travelling", "traveling", "trips"}},
+	{Slug: "volunteering", Name: "Volunteering", Category: "social", Emoji: "🤝", Synonyms: []string{"volunteer", "charity"}},
+	{Slug: "languages", Name: "Languages", Category: "social", Emoji: "🗣️", Synonyms: []string{"language exchange"}},
+	{Slug: "pets", Name: "Pets", Category: "social", Emoji: "🐶", Synonyms: []string{"dogs", "cats", "animals"}},
+}
+
+// Adds the interests catalog and maps the free-text interests of users and
+// chat finder entries onto it. Values matching no entry are moved to
+// legacy_interests and reported, so the catalog can be extended to cover them.
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection := &models.Collection{
+			Name:       "interests",
+			Type:       models.CollectionTypeBase,
+			ListRule:   types.Pointer("@request.auth.id != ''"),
+			ViewRule:   types.Pointer("@request.auth.id != ''"),
+			CreateRule: nil,
+			UpdateRule: nil,
+			DeleteRule: nil,
+			Schema: schema.NewSchema(
+				&schema.SchemaField{
+					Name:     "slug",
+					Type:     schema.FieldTypeText,
+					Required: true,
+					Options:  &schema.TextOptions{Pattern: "^[a-z0-9_]+$"},
+				},
+				&schema.SchemaField{
+					Name:     "name",
+					Type:     schema.FieldTypeText,
+					Required: true,
+					Options:  &schema.TextOptions{},
+				},
+				&schema.SchemaField{
+					Name:     "category",
+					Type:     schema.FieldTypeText,
+					Required: true,
+					Options:  &schema.TextOptions{},
+				},
+				&schema.SchemaField{
+					Name:    "emoji",
+					Type:    schema.FieldTypeText,
+					Options: &schema.TextOptions{},
+				},
+				&schema.SchemaField{
+					Name:    "synonyms",
+					Type:    schema.FieldTypeJson,
+					Options: &schema.JsonOptions{MaxSize: 2000},
+				},
+			),
+			Indexes: types.JsonArray[string]{
+				"CREATE UNIQUE INDEX `idx_interests_slug` ON `interests` (`slug`)",
+			},
+		}
+
+		if err := dao.SaveCollection(collection); err != nil {
+			return err
+		}
+
+		for _, entry := range interestsCatalog {
+			record := models.NewRecord(collection)
+			record.Set("slug", entry.Slug)
+			record.Set("name", entry.Name)
+			record.Set("category", entry.Category)
+			record.Set("emoji", entry.Emoji)
+			record.Set("synonyms", append([]string{}, entry.Synonyms...))
+			if err := dao.SaveRecord(record); err != nil {
+				return err
+			}
+		}
+
+		catalog := interests.NewCatalog(interestsCatalog)
+		unmatched := map[string]int{}
+		for _, target := range []string{"users", "chat_finder"} {
+			if err := mapFreeTextInterests(dao, catalog, target, unmatched); err != nil {
+				return err
+			}
+		}
+		reportUnmatchedInterests(unmatched)
+
+		return nil
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		for _, target := range []string{"users", "chat_finder"} {
+			collection, err := dao.FindCollectionByNameOrId(target)
+			if err != nil {
+				return err
+			}
+
+			if field := collection.Schema.GetFieldByName("legacy_interests"); field != nil {
+				collection.Schema.RemoveField(field.Id)
+			}
+
+			if err := dao.SaveCollection(collection); err != nil {
+				return err
+			}
+		}
+
+		collection, err := dao.FindCollectionByNameOrId("interests")
+		if err != nil {
+			return err
+		}
+
+		return dao.DeleteCollection(collection)
+	})
+}
+
+// mapFreeTextInterests replaces the interests of every row of the collection
+// with catalog slugs, keeping the values matching no entry in
+// legacy_interests. Unmatched values are counted in unmatched.
+func mapFreeTextInterests(dao *daos.Dao, catalog *interests.Catalog, collection string, unmatched map[string]int) error {
+	target, err := dao.FindCollectionByNameOrId(collection)
+	if err != nil {
+		return err
+	}
+
+	target.Schema.AddField(&schema.SchemaField{
+		Name:    "legacy_interests",
+		Type:    schema.FieldTypeJson,
+		Options: &schema.JsonOptions{MaxSize: 2000},
+	})
+
+	if err := dao.SaveCollection(target); err != nil {
+		return err
+	}
+
+	var rows []struct {
+		ID        string        `db:"id"`
+		Interests types.JsonRaw `db:"interests"`
+	}
+	if err := dao.DB().Select("id", "interests").From(collection).All(&rows); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		var values []string
+		if len(row.Interests) == 0 || json.Unmarshal(row.Interests, &values) != nil {
+			values = []string{}
+		}
+
+		slugs, unknown := catalog.ResolveAll(values)
+
+		params := dbx.Params{}
+		raw, err := json.Marshal(slugs)
+		if err != nil {
+			return err
+		}
+		params["interests"] = string(raw)
+
+		if len(unknown) > 0 {
+			raw, err := json.Marshal(unknown)
+			if err != nil {
+				return err
+			}
+			params["legacy_interests"] = string(raw)
+
+			for _, value := range unknown {
+				unmatched[interests.Normalize(value)]++
+			}
+		}
+
+		_, err = dao.DB().
+			Update(collection, params, dbx.HashExp{"id": row.ID}).
+			Execute()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reportUnmatchedInterests logs the values no catalog entry matched, most
+// used first, to help curate the catalog.
+func reportUnmatchedInterests(unmatched map[string]int) {
+	if len(unmatched) == 0 {
+		return
+	}
+
+	values := make([]string, 0, len(unmatched))
+	for value := range unmatched {
+		values = append(values, value)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if unmatched[values[i]] != unmatched[values[j]] {
+			return unmatched[values[i]] > unmatched[values[j]]
+		}
+		return values[i] < values[j]
+	})
+
+	log.Printf("Kept %d interest(s) matching no catalog entry in legacy_interests:", len(values))
+	for _, value := range values {
+		log.Printf("  %q used %d time(s)", value, unmatched[value])
+	}
+}
@@ -0,0 +1,122 @@
+package migrations
+
+import (
+	"github.com/bogdancanciu/frekathon-backend/interests"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"golang.org/x/exp/slices"
+)
+
+// subInterests are added under the catalog interests.
+var subInterests = []interests.Entry{
+	{Slug: "bouldering", Name: "Bouldering", Category: "outdoors", Parent: "climbing", Emoji: "🧗", Synonyms: []string{"boulder"}},
+	{Slug: "sport_climbing", Name: "Sport climbing", Category: "outdoors", Parent: "climbing", Emoji: "🧗", Synonyms: []string{"lead climbing"}},
+	{Slug: "backpacking", Name: "Backpacking", Category: "outdoors", Parent: "hiking", Emoji: "🎒", Synonyms: []string{"thru hiking"}},
+	{Slug: "mountain_biking", Name: "Mountain biking", Category: "outdoors", Parent: "cycling", Emoji: "🚵", Synonyms: []string{"mtb"}},
+	{Slug: "road_cycling", Name: "Road cycling", Category: "outdoors", Parent: "cycling", Emoji: "🚴", Synonyms: []string{"road biking"}},
+	{Slug: "trail_running", Name: "Trail running", Category: "sports", Parent: "running", Emoji: "🏃", Synonyms: []string{"trail run"}},
+	{Slug: "padel", Name: "Padel", Category: "sports", Parent: "tennis", Emoji: "🎾"},
+	{Slug: "baking", Name: "Baking", Category: "food", Parent: "cooking", Emoji: "🥐", Synonyms: []string{"pastry"}},
+	{Slug: "esports", Name: "Esports", Category: "games", Parent: "video_games", Emoji: "🕹️", Synonyms: []string{"competitive gaming"}},
+	{Slug: "street_photography", Name: "Street photography", Category: "arts", Parent: "photography", Emoji: "📸"},
+	{Slug: "jazz", Name: "Jazz", Category: "music", Parent: "concerts", Emoji: "🎷"},
+}
+
+// promotedSynonyms were synonyms of an interest and are sub-interests now.
+var promotedSynonyms = map[string][]string{
+	"climbing":    {"bouldering"},
+	"tennis":      {"padel"},
+	"cooking":     {"baking"},
+	"video_games": {"esports"},
+}
+
+// Nests interests into a category, interest and sub-interest hierarchy so
+// matching can group related interests.
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("interests")
+		if err != nil {
+			return err
+		}
+
+		collection.Schema.AddField(&schema.SchemaField{
+			Name:    "parent",
+			Type:    schema.FieldTypeText,
+			Options: &schema.TextOptions{},
+		})
+
+		if err := dao.SaveCollection(collection); err != nil {
+			return err
+		}
+
+		for slug, promoted := range promotedSynonyms {
+			if err := updateSynonyms(dao, slug, func(synonyms []string) []string {
+				return slices.DeleteFunc(synonyms, func(synonym string) bool {
+					return slices.Contains(promoted, synonym)
+				})
+			}); err != nil {
+				return err
+			}
+		}
+
+		for _, entry := range subInterests {
+			record := models.NewRecord(collection)
+			record.Set("slug", entry.Slug)
+			record.Set("name", entry.Name)
+			record.Set("category", entry.Category)
+			record.Set("parent", entry.Parent)
+			record.Set("emoji", entry.Emoji)
+			record.Set("synonyms", append([]string{}, entry.Synonyms...))
+			if err := dao.SaveRecord(record); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		for _, entry := range subInterests {
+			record, err := dao.FindFirstRecordByData("interests", "slug", entry.Slug)
+			if err != nil {
+				return err
+			}
+			if err := dao.DeleteRecord(record); err != nil {
+				return err
+			}
+		}
+
+		for slug, promoted := range promotedSynonyms {
+			if err := updateSynonyms(dao, slug, func(synonyms []string) []string {
+				return append(synonyms, promoted...)
+			}); err != nil {
+				return err
+			}
+		}
+
+		collection, err := dao.FindCollectionByNameOrId("interests")
+		if err != nil {
+			return err
+		}
+
+		collection.Schema.RemoveField(collection.Schema.GetFieldByName("parent").Id)
+
+		return dao.SaveCollection(collection)
+	})
+}
+
+func updateSynonyms(dao *daos.Dao, slug string, update func([]string) []string) error {
+	record, err := dao.FindFirstRecordByData("interests", "slug", slug)
+	if err != nil {
+		return err
+	}
+
+	record.Set("synonyms", update(record.GetStringSlice("synonyms")))
+
+	return dao.SaveRecord(record)
+}
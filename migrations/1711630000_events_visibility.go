@@ -0,0 +1,68 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Adds who may see and attend an event. Existing events stay visible to the
+// friends of their creator. Reads through the records API are limited to the
+// creator and attendants, everyone else goes through /api/events.
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("events")
+		if err != nil {
+			return err
+		}
+
+		collection.Schema.AddField(&schema.SchemaField{
+			Name: "visibility",
+			Type: schema.FieldTypeSelect,
+			Options: &schema.SelectOptions{
+				MaxSelect: 1,
+				Values:    []string{"friends", "friends_of_friends", "public", "invite_only"},
+			},
+		})
+		collection.Schema.AddField(&schema.SchemaField{
+			Name:    "invited",
+			Type:    schema.FieldTypeJson,
+			Options: &schema.JsonOptions{MaxSize: 2000000},
+		})
+
+		collection.ListRule = types.Pointer("user_id = @request.auth.id || attendants ~ @request.auth.id")
+		collection.ViewRule = types.Pointer("user_id = @request.auth.id || attendants ~ @request.auth.id")
+
+		if err := dao.SaveCollection(collection); err != nil {
+			return err
+		}
+
+		_, err = dao.DB().
+			Update("events", dbx.Params{"visibility": "friends", "invited": "[]"}, nil).
+			Execute()
+
+		return err
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("events")
+		if err != nil {
+			return err
+		}
+
+		for _, name := range []string{"visibility", "invited"} {
+			if field := collection.Schema.GetFieldByName(name); field != nil {
+				collection.Schema.RemoveField(field.Id)
+			}
+		}
+
+		collection.ListRule = types.Pointer("@request.auth.id != \"\"")
+		collection.ViewRule = types.Pointer("@request.auth.id != \"\"")
+
+		return dao.SaveCollection(collection)
+	})
+}
@@ -0,0 +1,75 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// eventsUpdateRule keeps creators from editing the attendance of their events
+// through the records API, capacity changes go through the capacity endpoint
+// so the waitlist gets promoted.
+const eventsUpdateRule = "user_id = @request.auth.id" +
+	" && @request.data.attendants:isset = false" +
+	" && @request.data.waitlist:isset = false" +
+	" && @request.data.max_attendants:isset = false"
+
+// Turns the required limit of events into an optional max_attendants, 0
+// meaning unlimited, and adds the ordered waitlist of users waiting for a
+// spot.
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("events")
+		if err != nil {
+			return err
+		}
+
+		// renaming the field keeps the values of the column
+		limit := collection.Schema.GetFieldByName("limit")
+		limit.Name = "max_attendants"
+		limit.Required = false
+		limit.Options = &schema.NumberOptions{
+			Min:       types.Pointer(0.0),
+			NoDecimal: true,
+		}
+		collection.Schema.AddField(&schema.SchemaField{
+			Name:    "waitlist",
+			Type:    schema.FieldTypeJson,
+			Options: &schema.JsonOptions{MaxSize: 2000000},
+		})
+		collection.UpdateRule = types.Pointer(eventsUpdateRule)
+
+		if err := dao.SaveCollection(collection); err != nil {
+			return err
+		}
+
+		_, err = dao.DB().
+			NewQuery("UPDATE events SET waitlist = '[]', max_attendants = MAX(CAST(COALESCE(max_attendants, 0) AS INTEGER), 0)").
+			Execute()
+
+		return err
+	}, func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		collection, err := dao.FindCollectionByNameOrId("events")
+		if err != nil {
+			return err
+		}
+
+		if field := collection.Schema.GetFieldByName("waitlist"); field != nil {
+			collection.Schema.RemoveField(field.Id)
+		}
+		if field := collection.Schema.GetFieldByName("max_attendants"); field != nil {
+			field.Name = "limit"
+			field.Required = true
+			field.Options = &schema.NumberOptions{}
+		}
+		collection.UpdateRule = types.Pointer("user_id = @request.auth.id")
+
+		return dao.SaveCollection(collection)
+	})
+}
@@ -0,0 +1,80 @@
+package migrations
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/daos"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+)
+
+var friendshipStatuses = []string{"pending", "accepted", "declined"}
+
+// Blocks live in user_blocks, drops the blocked friendship status. Rows that
+// still hold it become a block by the requester.
+func init() {
+	m.Register(func(db dbx.Builder) error {
+		dao := daos.New(db)
+
+		var blocked []struct {
+			ID        string `db:"id"`
+			Requester string `db:"requester"`
+			Addressee string `db:"addressee"`
+		}
+		err := dao.DB().
+			Select("id", "requester", "addressee").
+			From("friendships").
+			Where(dbx.HashExp{"status": "blocked"}).
+			All(&blocked)
+		if err != nil {
+			return err
+		}
+
+		blocks, err := dao.FindCollectionByNameOrId("user_blocks")
+		if err != nil {
+			return err
+		}
+
+		for _, friendship := range blocked {
+			exists, err := dao.FindFirstRecordByFilter("user_blocks",
+				"user = {:user} && target = {:target} && kind = 'block'",
+				dbx.Params{"user": friendship.Requester, "target": friendship.Addressee},
+			)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+
+			if exists == nil {
+				block := models.NewRecord(blocks)
+				block.Set("user", friendship.Requester)
+				block.Set("target", friendship.Addressee)
+				block.Set("kind", "block")
+				if err := dao.SaveRecord(block); err != nil {
+					return err
+				}
+			}
+
+			if _, err := dao.DB().Delete("friendships", dbx.HashExp{"id": friendship.ID}).Execute(); err != nil {
+				return err
+			}
+		}
+
+		return setFriendshipStatuses(dao, friendshipStatuses)
+	}, func(db dbx.Builder) error {
+		return setFriendshipStatuses(daos.New(db), append(friendshipStatuses, "blocked"))
+	})
+}
+
+func setFriendshipStatuses(dao *daos.Dao, statuses []string) error {
+	collection, err := dao.FindCollectionByNameOrId("friendships")
+	if err != nil {
+		return err
+	}
+
+	field := collection.Schema.GetFieldByName("status")
+	field.Options = &schema.SelectOptions{MaxSelect: 1, Values: statuses}
+
+	return dao.SaveCollection(collection)
+}
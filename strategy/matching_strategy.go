@@ -5,10 +5,22 @@ import "reflect"
 type User struct {
 	ID        string
 	Interests []string
+	// Blocked holds the IDs of the users this user must never be grouped with.
+	Blocked []string
+}
+
+// Taxonomy relates interests that aren't equal. Common returns the most
+// specific interest or category both belong to and how similar they are,
+// from 0 for unrelated interests to 1 for the same one.
+type Taxonomy interface {
+	Common(a, b string) (string, float64)
 }
 
 type MatchingStrategy struct {
 	users []*User
+
+	taxonomy  Taxonomy
+	threshold float64
 }
 
 func NewMatchingStrategy(users []*User) *MatchingStrategy {
@@ -17,13 +29,22 @@ func NewMatchingStrategy(users []*User) *MatchingStrategy {
 	}
 }
 
+// WithTaxonomy lets related interests match when their similarity reaches
+// the threshold, the group then shares their common ancestor. Without a
+// taxonomy only equal interests match.
+func (ms *MatchingStrategy) WithTaxonomy(taxonomy Taxonomy, threshold float64) *MatchingStrategy {
+	ms.taxonomy = taxonomy
+	ms.threshold = threshold
+	return ms
+}
+
 func (ms *MatchingStrategy) FindMatchingGroups() ([][]*User, [][]string) {
 	var matchingUsers [][]*User
 	var commonInterests [][]string
 
 	for groupSize := 3; groupSize <= 6; groupSize++ {
 		for i := 0; i < len(ms.users); i++ {
-			groups, interests := generateGroup(ms.users, i)
+			groups, interests := ms.generateGroup(i)
 			for j := range groups {
 				if !contains(matchingUsers, groups[j]) {
 					matchingUsers = append(matchingUsers, groups[j])
@@ -36,7 +57,8 @@ func (ms *MatchingStrategy) FindMatchingGroups() ([][]*User, [][]string) {
 	return matchingUsers, commonInterests
 }
 
-func generateGroup(users []*User, startIndex int) ([][]*User, [][]string) {
+func (ms *MatchingStrategy) generateGroup(startIndex int) ([][]*User, [][]string) {
+	users := ms.users
 	var matchingUsers [][]*User
 	var commonInterests [][]string
 
@@ -48,7 +70,10 @@ func generateGroup(users []*User, startIndex int) ([][]*User, [][]string) {
 		for j := i + 1; j < len(users); j++ {
 			for k := j + 1; k < len(users); k++ {
 				usersSubset := []*User{users[i], users[j], users[k]}
-				common := intersectAll(usersSubset)
+				if anyBlocked(usersSubset) {
+					continue
+				}
+				common := ms.intersectAll(usersSubset)
 				if len(common) > 0 {
 					matchingUsers = append(matchingUsers, usersSubset)
 					commonInterests = append(commonInterests, common)
@@ -59,27 +84,66 @@ func generateGroup(users []*User, startIndex int) ([][]*User, [][]string) {
 	return matchingUsers, commonInterests
 }
 
-func intersectAll(users []*User) []string {
+func anyBlocked(users []*User) bool {
+	for _, user := range users {
+		for _, other := range users {
+			for _, blocked := range user.Blocked {
+				if blocked == other.ID {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (ms *MatchingStrategy) intersectAll(users []*User) []string {
 	intersection := users[0].Interests
 	for _, user := range users[1:] {
-		intersection = intersect(intersection, user.Interests)
+		intersection = ms.intersect(intersection, user.Interests)
 	}
 	return intersection
 }
 
-func intersect(slice1, slice2 []string) []string {
+func (ms *MatchingStrategy) intersect(slice1, slice2 []string) []string {
 	var intersection []string
 	for _, interest := range slice1 {
-		for _, interest2 := range slice2 {
-			if interest == interest2 {
-				intersection = append(intersection, interest)
-				break
-			}
+		if common, ok := ms.bestMatch(interest, slice2); ok && !containsString(intersection, common) {
+			intersection = append(intersection, common)
 		}
 	}
 	return intersection
 }
 
+// bestMatch returns what the interest shares with its closest interest in
+// the slice, preferring equal interests.
+func (ms *MatchingStrategy) bestMatch(interest string, slice []string) (string, bool) {
+	if containsString(slice, interest) {
+		return interest, true
+	}
+	if ms.taxonomy == nil {
+		return "", false
+	}
+
+	best, bestSimilarity := "", 0.0
+	for _, other := range slice {
+		common, similarity := ms.taxonomy.Common(interest, other)
+		if similarity >= ms.threshold && similarity > bestSimilarity {
+			best, bestSimilarity = common, similarity
+		}
+	}
+	return best, best != ""
+}
+
+func containsString(slice []string, value string) bool {
+	for _, item := range slice {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
 func contains(groups [][]*User, group []*User) bool {
 	for _, g := range groups {
 		if reflect.DeepEqual(g, group) {
@@ -0,0 +1,208 @@
+package strategy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func groupIds(groups [][]*User) [][]string {
+	ids := [][]string{}
+	for _, group := range groups {
+		var groupIds []string
+		for _, user := range group {
+			groupIds = append(groupIds, user.ID)
+		}
+		ids = append(ids, groupIds)
+	}
+	return ids
+}
+
+func TestAnyBlocked(t *testing.T) {
+	tests := []struct {
+		name  string
+		users []*User
+		want  bool
+	}{
+		{
+			name:  "should allow users without blocks",
+			users: []*User{{ID: "a"}, {ID: "b"}, {ID: "c"}},
+			want:  false,
+		},
+		{
+			name:  "should ignore blocks of users outside the group",
+			users: []*User{{ID: "a", Blocked: []string{"d"}}, {ID: "b"}, {ID: "c"}},
+			want:  false,
+		},
+		{
+			name:  "should refuse a group with a blocked member",
+			users: []*User{{ID: "a"}, {ID: "b", Blocked: []string{"c"}}, {ID: "c"}},
+			want:  true,
+		},
+		{
+			name:  "should refuse a group whatever the side recording the block",
+			users: []*User{{ID: "a"}, {ID: "b"}, {ID: "c", Blocked: []string{"a"}}},
+			want:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, anyBlocked(tt.users))
+		})
+	}
+}
+
+func TestFindMatchingGroupsWithBlocks(t *testing.T) {
+	tests := []struct {
+		name   string
+		users  []*User
+		groups [][]string
+	}{
+		{
+			name: "should group users sharing an interest",
+			users: []*User{
+				{ID: "a", Interests: []string{"chess"}},
+				{ID: "b", Interests: []string{"chess"}},
+				{ID: "c", Interests: []string{"chess"}},
+			},
+			groups: [][]string{{"a", "b", "c"}},
+		},
+		{
+			name: "should not group users who blocked each other",
+			users: []*User{
+				{ID: "a", Interests: []string{"chess"}, Blocked: []string{"b"}},
+				{ID: "b", Interests: []string{"chess"}},
+				{ID: "c", Interests: []string{"chess"}},
+			},
+			groups: [][]string{},
+		},
+		{
+			name: "should group around the blocked user",
+			users: []*User{
+				{ID: "a", Interests: []string{"chess"}},
+				{ID: "b", Interests: []string{"chess"}, Blocked: []string{"d"}},
+				{ID: "c", Interests: []string{"chess"}},
+				{ID: "d", Interests: []string{"chess"}},
+			},
+			groups: [][]string{{"a", "b", "c"}, {"a", "c", "d"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groups, _ := NewMatchingStrategy(tt.users).FindMatchingGroups()
+			assert.Equal(t, tt.groups, groupIds(groups))
+		})
+	}
+}
+
+// stubTaxonomy relates the interest pairs it lists, in either order.
+type stubTaxonomy map[[2]string]struct {
+	common     string
+	similarity float64
+}
+
+func (st stubTaxonomy) Common(a, b string) (string, float64) {
+	if relation, ok := st[[2]string{a, b}]; ok {
+		return relation.common, relation.similarity
+	}
+	if relation, ok := st[[2]string{b, a}]; ok {
+		return relation.common, relation.similarity
+	}
+	return "", 0
+}
+
+func TestBestMatch(t *testing.T) {
+	taxonomy := stubTaxonomy{
+		{"bouldering", "climbing"}: {common: "climbing", similarity: 0.8},
+		{"bouldering", "hiking"}:   {common: "outdoors", similarity: 0.6},
+		{"bouldering", "running"}:  {common: "sports", similarity: 0.4},
+	}
+
+	tests := []struct {
+		name     string
+		taxonomy Taxonomy
+		slice    []string
+		want     string
+		ok       bool
+	}{
+		{
+			name:  "should only match equal interests without a taxonomy",
+			slice: []string{"climbing"},
+			want:  "",
+			ok:    false,
+		},
+		{
+			name:     "should not match interests below the threshold",
+			taxonomy: taxonomy,
+			slice:    []string{"running"},
+			want:     "",
+			ok:       false,
+		},
+		{
+			name:     "should match interests at the threshold",
+			taxonomy: taxonomy,
+			slice:    []string{"hiking"},
+			want:     "outdoors",
+			ok:       true,
+		},
+		{
+			name:     "should prefer the most similar interest",
+			taxonomy: taxonomy,
+			slice:    []string{"running", "hiking", "climbing"},
+			want:     "climbing",
+			ok:       true,
+		},
+		{
+			name:     "should prefer an equal interest over a related one",
+			taxonomy: taxonomy,
+			slice:    []string{"climbing", "bouldering"},
+			want:     "bouldering",
+			ok:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ms := NewMatchingStrategy(nil)
+			if tt.taxonomy != nil {
+				ms.WithTaxonomy(tt.taxonomy, 0.6)
+			}
+
+			got, ok := ms.bestMatch("bouldering", tt.slice)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.ok, ok)
+		})
+	}
+}
+
+func TestFindMatchingGroupsWithTaxonomy(t *testing.T) {
+	taxonomy := stubTaxonomy{
+		{"bouldering", "climbing"}: {common: "climbing", similarity: 0.8},
+		{"hiking", "climbing"}:     {common: "outdoors", similarity: 0.6},
+		{"hiking", "bouldering"}:   {common: "outdoors", similarity: 0.5},
+	}
+
+	t.Run("should group related interests under their common interest", func(t *testing.T) {
+		users := []*User{
+			{ID: "a", Interests: []string{"climbing"}},
+			{ID: "b", Interests: []string{"bouldering"}},
+			{ID: "c", Interests: []string{"climbing"}},
+		}
+
+		groups, interests := NewMatchingStrategy(users).WithTaxonomy(taxonomy, 0.6).FindMatchingGroups()
+		assert.Equal(t, [][]string{{"a", "b", "c"}}, groupIds(groups))
+		assert.Equal(t, [][]string{{"climbing"}}, interests)
+	})
+
+	t.Run("should not group interests below the threshold", func(t *testing.T) {
+		users := []*User{
+			{ID: "a", Interests: []string{"hiking"}},
+			{ID: "b", Interests: []string{"bouldering"}},
+			{ID: "c", Interests: []string{"hiking"}},
+		}
+
+		groups, _ := NewMatchingStrategy(users).WithTaxonomy(taxonomy, 0.6).FindMatchingGroups()
+		assert.Empty(t, groups)
+	})
+}